@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+//parte que guarda resultados já calculados, a chave é um hash de tudo que influencia o resultado
+
+type Entry struct {
+	ContentType string
+	Data        []byte
+}
+
+func (entry *Entry) size() int64 {
+	return int64(len(entry.ContentType) + len(entry.Data))
+}
+
+//cada camada tem o seu limite de tamanho e descarta primeiro o que foi usado há mais tempo
+
+type Tier interface {
+	Name() string
+	Get(key string) (*Entry, bool)
+	Put(key string, entry *Entry) error
+}
+
+type Cache struct {
+	tiers []Tier
+}
+
+//as camadas vão da mais rápida para a mais lenta
+
+func New(tiers ...Tier) *Cache {
+	return &Cache{tiers: tiers}
+}
+
+//um acerto numa camada lenta é copiado para as mais rápidas, devolve o nome da camada que tinha a entrada
+
+func (cache *Cache) Get(key string) (*Entry, string, bool) {
+	for i, tier := range cache.tiers {
+		entry, exists := tier.Get(key)
+		if !exists {
+			continue
+		}
+
+		for _, fasterTier := range cache.tiers[:i] {
+			fasterTier.Put(key, entry)
+		}
+
+		return entry, tier.Name(), true
+	}
+
+	return nil, "", false
+}
+
+func (cache *Cache) Put(key string, entry *Entry) error {
+	var firstErr error
+
+	for _, tier := range cache.tiers {
+		err := tier.Put(key, entry)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+//camada em memória
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+}
+
+type MemoryTier struct {
+	mutex    sync.Mutex
+	maxBytes int64
+	used     int64
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+func NewMemoryTier(maxBytes int64) *MemoryTier {
+	return &MemoryTier{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (tier *MemoryTier) Name() string {
+	return "memory"
+}
+
+func (tier *MemoryTier) Get(key string) (*Entry, bool) {
+	tier.mutex.Lock()
+	defer tier.mutex.Unlock()
+
+	element, exists := tier.items[key]
+	if !exists {
+		return nil, false
+	}
+
+	tier.order.MoveToFront(element)
+
+	return element.Value.(*memoryItem).entry, true
+}
+
+//entradas maiores que a camada inteira não são guardadas
+
+func (tier *MemoryTier) Put(key string, entry *Entry) error {
+	tier.mutex.Lock()
+	defer tier.mutex.Unlock()
+
+	if entry.size() > tier.maxBytes {
+		return nil
+	}
+
+	if element, exists := tier.items[key]; exists {
+		tier.order.MoveToFront(element)
+		return nil
+	}
+
+	tier.items[key] = tier.order.PushFront(&memoryItem{key: key, entry: entry})
+	tier.used += entry.size()
+
+	for tier.used > tier.maxBytes {
+		oldest := tier.order.Back()
+		item := oldest.Value.(*memoryItem)
+
+		tier.order.Remove(oldest)
+		delete(tier.items, item.key)
+		tier.used -= item.entry.size()
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package cache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//com o content type de um byte e 9 de dados, cada entrada ocupa 10 bytes na memória e 11 no disco, que guarda também a quebra de linha
+
+const defaultDataSize = 9
+
+type cacheStep struct {
+	op   string
+	key  string
+	size int
+	want string
+}
+
+func testEntry(key string, size int) *Entry {
+	if size == 0 {
+		size = defaultDataSize
+	}
+
+	return &Entry{ContentType: "x", Data: bytes.Repeat([]byte(key[:1]), size)}
+}
+
+func openTestCache(t *testing.T, dir string, memorySize int64, diskSize int64) *Cache {
+	tiers := []Tier{}
+
+	if memorySize > 0 {
+		tiers = append(tiers, NewMemoryTier(memorySize))
+	}
+
+	if diskSize > 0 {
+		diskTier, err := NewDiskTier(dir, diskSize)
+		if err != nil {
+			t.Fatalf("opening the disk tier: %v", err)
+		}
+
+		tiers = append(tiers, diskTier)
+	}
+
+	return New(tiers...)
+}
+
+//get espera o nome da camada que tinha a entrada ou miss, touch muda a data de modificação do arquivo para size segundos depois de um instante fixo e restart abre as camadas de novo no mesmo diretório, com size como o novo limite do disco
+
+func TestCache(t *testing.T) {
+	cases := []struct {
+		name       string
+		memorySize int64
+		diskSize   int64
+		steps      []cacheStep
+	}{
+		{
+			name:       "memory evicts the least recently used entry",
+			memorySize: 30,
+			steps: []cacheStep{
+				{op: "put", key: "a1"},
+				{op: "put", key: "b2"},
+				{op: "put", key: "c3"},
+				{op: "get", key: "a1", want: "memory"},
+				{op: "put", key: "d4"},
+				{op: "get", key: "b2", want: "miss"},
+				{op: "get", key: "a1", want: "memory"},
+				{op: "get", key: "c3", want: "memory"},
+				{op: "get", key: "d4", want: "memory"},
+			},
+		},
+		{
+			name:     "disk evicts the least recently used entry",
+			diskSize: 22,
+			steps: []cacheStep{
+				{op: "put", key: "a1"},
+				{op: "put", key: "b2"},
+				{op: "get", key: "a1", want: "disk"},
+				{op: "put", key: "c3"},
+				{op: "get", key: "b2", want: "miss"},
+				{op: "get", key: "a1", want: "disk"},
+				{op: "get", key: "c3", want: "disk"},
+			},
+		},
+		{
+			name:       "entries larger than every tier are not stored",
+			memorySize: 30,
+			diskSize:   20,
+			steps: []cacheStep{
+				{op: "put", key: "a1", size: 30},
+				{op: "get", key: "a1", want: "miss"},
+				{op: "put", key: "b2"},
+				{op: "get", key: "b2", want: "memory"},
+			},
+		},
+		{
+			name:       "entries larger than memory stay on disk",
+			memorySize: 10,
+			diskSize:   100,
+			steps: []cacheStep{
+				{op: "put", key: "a1", size: 20},
+				{op: "get", key: "a1", want: "disk"},
+				{op: "get", key: "a1", want: "disk"},
+			},
+		},
+		{
+			name:       "disk hits are promoted to memory",
+			memorySize: 10,
+			diskSize:   100,
+			steps: []cacheStep{
+				{op: "put", key: "a1"},
+				{op: "put", key: "b2"},
+				{op: "get", key: "a1", want: "disk"},
+				{op: "get", key: "a1", want: "memory"},
+				{op: "get", key: "b2", want: "disk"},
+				{op: "get", key: "b2", want: "memory"},
+			},
+		},
+		{
+			name:       "disk entries survive a restart and memory does not",
+			memorySize: 30,
+			diskSize:   100,
+			steps: []cacheStep{
+				{op: "put", key: "a1"},
+				{op: "get", key: "a1", want: "memory"},
+				{op: "restart", size: 100},
+				{op: "get", key: "a1", want: "disk"},
+				{op: "get", key: "a1", want: "memory"},
+			},
+		},
+		{
+			name:     "a restart with a smaller limit drops the oldest files",
+			diskSize: 100,
+			steps: []cacheStep{
+				{op: "put", key: "a1"},
+				{op: "put", key: "b2"},
+				{op: "put", key: "c3"},
+				{op: "touch", key: "b2", size: 1},
+				{op: "touch", key: "a1", size: 2},
+				{op: "touch", key: "c3", size: 3},
+				{op: "restart", size: 22},
+				{op: "get", key: "b2", want: "miss"},
+				{op: "get", key: "a1", want: "disk"},
+				{op: "get", key: "c3", want: "disk"},
+			},
+		},
+		{
+			name:     "reads on disk count as use across a restart",
+			diskSize: 100,
+			steps: []cacheStep{
+				{op: "put", key: "a1"},
+				{op: "put", key: "b2"},
+				{op: "put", key: "c3"},
+				{op: "touch", key: "a1", size: 1},
+				{op: "touch", key: "b2", size: 2},
+				{op: "touch", key: "c3", size: 3},
+				{op: "get", key: "a1", want: "disk"},
+				{op: "restart", size: 22},
+				{op: "get", key: "b2", want: "miss"},
+				{op: "get", key: "a1", want: "disk"},
+				{op: "get", key: "c3", want: "disk"},
+			},
+		},
+	}
+
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cache := openTestCache(t, dir, testCase.memorySize, testCase.diskSize)
+			stored := map[string]*Entry{}
+
+			for i, step := range testCase.steps {
+				switch step.op {
+				case "put":
+					entry := testEntry(step.key, step.size)
+					stored[step.key] = entry
+
+					err := cache.Put(step.key, entry)
+					if err != nil {
+						t.Fatalf("step %d: put %s: %v", i, step.key, err)
+					}
+
+				case "get":
+					entry, tier, exists := cache.Get(step.key)
+
+					got := "miss"
+					if exists {
+						got = tier
+					}
+
+					if got != step.want {
+						t.Fatalf("step %d: get %s came from %s, want %s", i, step.key, got, step.want)
+					}
+
+					if exists && (entry.ContentType != stored[step.key].ContentType || !bytes.Equal(entry.Data, stored[step.key].Data)) {
+						t.Fatalf("step %d: get %s returned different content than was stored", i, step.key)
+					}
+
+				case "touch":
+					modified := base.Add(time.Duration(step.size) * time.Second)
+
+					err := os.Chtimes(filepath.Join(dir, step.key), modified, modified)
+					if err != nil {
+						t.Fatalf("step %d: touch %s: %v", i, step.key, err)
+					}
+
+				case "restart":
+					cache = openTestCache(t, dir, testCase.memorySize, int64(step.size))
+
+				default:
+					t.Fatalf("step %d: unknown op %s", i, step.op)
+				}
+			}
+		})
+	}
+}
+
+//o diretório pode ter arquivos que não são do cache, eles não contam no limite e não são apagados
+
+func TestDiskTierIgnoresForeignFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	foreign := filepath.Join(dir, "README.txt")
+
+	err := os.WriteFile(foreign, bytes.Repeat([]byte("x"), 100), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := openTestCache(t, dir, 0, 22)
+
+	err = cache.Put("a1", testEntry("a1", 0))
+	if err != nil {
+		t.Fatalf("put a1: %v", err)
+	}
+
+	if _, _, exists := cache.Get("a1"); !exists {
+		t.Fatal("a1 was evicted because of a file that is not a cache entry")
+	}
+
+	if _, err := os.Stat(foreign); err != nil {
+		t.Fatalf("the foreign file was removed: %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+//parte que guarda os resultados em disco, um arquivo por chave com o content type na primeira linha
+
+type diskItem struct {
+	key  string
+	size int64
+}
+
+type DiskTier struct {
+	mutex    sync.Mutex
+	dir      string
+	maxBytes int64
+	used     int64
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+//os arquivos que já estavam no diretório continuam valendo, os modificados há mais tempo saem primeiro
+
+func NewDiskTier(dir string, maxBytes int64) (*DiskTier, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	tier := &DiskTier{
+		dir:      dir,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    map[string]*list.Element{},
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []os.FileInfo{}
+
+	for _, dirEntry := range dirEntries {
+		info, err := dirEntry.Info()
+		if err != nil || !info.Mode().IsRegular() || !validKey(info.Name()) {
+			continue
+		}
+
+		files = append(files, info)
+	}
+
+	sort.Slice(files, func(i int, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	for _, info := range files {
+		tier.items[info.Name()] = tier.order.PushFront(&diskItem{key: info.Name(), size: info.Size()})
+		tier.used += info.Size()
+	}
+
+	tier.evict()
+
+	return tier, nil
+}
+
+//as chaves viram nomes de arquivo, então só hexadecimal é aceito
+
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for _, c := range key {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (tier *DiskTier) Name() string {
+	return "disk"
+}
+
+func (tier *DiskTier) path(key string) string {
+	return filepath.Join(tier.dir, key)
+}
+
+func (tier *DiskTier) Get(key string) (*Entry, bool) {
+	if !validKey(key) {
+		return nil, false
+	}
+
+	tier.mutex.Lock()
+	element, exists := tier.items[key]
+	if exists {
+		tier.order.MoveToFront(element)
+	}
+	tier.mutex.Unlock()
+
+	if !exists {
+		return nil, false
+	}
+
+	content, err := os.ReadFile(tier.path(key))
+	if err != nil {
+		tier.remove(key)
+		return nil, false
+	}
+
+	contentType, data, found := bytes.Cut(content, []byte("\n"))
+	if !found {
+		tier.remove(key)
+		return nil, false
+	}
+
+	//a data de modificação guarda a ordem de uso para quando o servidor reiniciar
+	now := time.Now()
+	os.Chtimes(tier.path(key), now, now)
+
+	return &Entry{ContentType: string(contentType), Data: data}, true
+}
+
+func (tier *DiskTier) Put(key string, entry *Entry) error {
+	if !validKey(key) {
+		return errors.New("invalid cache key " + key)
+	}
+
+	size := int64(len(entry.ContentType) + 1 + len(entry.Data))
+
+	if size > tier.maxBytes {
+		return nil
+	}
+
+	tier.mutex.Lock()
+	_, exists := tier.items[key]
+	tier.mutex.Unlock()
+
+	if exists {
+		return nil
+	}
+
+	//escreve num arquivo temporário e renomeia para que ninguém leia um arquivo pela metade
+	tempFile, err := os.CreateTemp(tier.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = tempFile.Write(append([]byte(entry.ContentType+"\n"), entry.Data...))
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tempFile.Name(), tier.path(key))
+	}
+
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+
+	tier.mutex.Lock()
+	defer tier.mutex.Unlock()
+
+	if _, exists := tier.items[key]; !exists {
+		tier.items[key] = tier.order.PushFront(&diskItem{key: key, size: size})
+		tier.used += size
+	}
+
+	tier.evict()
+
+	return nil
+}
+
+func (tier *DiskTier) remove(key string) {
+	tier.mutex.Lock()
+	defer tier.mutex.Unlock()
+
+	if element, exists := tier.items[key]; exists {
+		tier.order.Remove(element)
+		delete(tier.items, key)
+		tier.used -= element.Value.(*diskItem).size
+	}
+
+	os.Remove(tier.path(key))
+}
+
+//chamado com o mutex travado
+
+func (tier *DiskTier) evict() {
+	for tier.used > tier.maxBytes {
+		oldest := tier.order.Back()
+		item := oldest.Value.(*diskItem)
+
+		tier.order.Remove(oldest)
+		delete(tier.items, item.key)
+		tier.used -= item.size
+
+		os.Remove(tier.path(item.key))
+	}
+}
@@ -0,0 +1,509 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+//parte que lida com a configuração do servidor
+
+type Config struct {
+	Addr             string   `yaml:"addr"`
+	TLSCert          string   `yaml:"tlsCert"`
+	TLSKey           string   `yaml:"tlsKey"`
+	MaxBodySize      int64    `yaml:"maxBodySize"`
+	MaxWidth         int      `yaml:"maxWidth"`
+	MaxHeight        int      `yaml:"maxHeight"`
+	MaxPixels        int64    `yaml:"maxPixels"`
+	MemoryBudget     int64    `yaml:"memoryBudget"`
+	CORSOrigins      []string `yaml:"corsOrigins"`
+	EnabledEndpoints []string `yaml:"endpoints"`
+	LogLevel         string   `yaml:"logLevel"`
+
+	RequestTimeout  time.Duration `yaml:"requestTimeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	MemoryWait      time.Duration `yaml:"memoryWait"`
+
+	JobWorkers   int           `yaml:"jobWorkers"`
+	JobQueueSize int           `yaml:"jobQueueSize"`
+	JobTTL       time.Duration `yaml:"jobTTL"`
+
+	BatchWorkers  int `yaml:"batchWorkers"`
+	BatchMaxFiles int `yaml:"batchMaxFiles"`
+
+	//tamanho descompactado de cada imagem do lote e de todas juntas
+	BatchMaxFileSize  int64 `yaml:"batchMaxFileSize"`
+	BatchMaxTotalSize int64 `yaml:"batchMaxTotalSize"`
+
+	//sessões de edição por WebSocket
+	SessionHistory     int           `yaml:"sessionHistory"`
+	SessionIdleTimeout time.Duration `yaml:"sessionIdleTimeout"`
+
+	//cada chave tem o formato cliente:chave
+	APIKeys    []string `yaml:"apiKeys"`
+	AuthSecret string   `yaml:"authSecret"`
+
+	//limites por cliente, o cliente é quem se autenticou ou o IP quando não há autenticação
+	RateLimit         float64 `yaml:"rateLimit"`
+	RateBurst         int     `yaml:"rateBurst"`
+	ClientConcurrency int     `yaml:"clientConcurrency"`
+
+	//proxies cujos X-Forwarded-For e X-Real-IP são aceitos como o IP do cliente, nenhum por padrão
+	TrustedProxies []string `yaml:"trustedProxies"`
+
+	CacheSize     int64  `yaml:"cacheSize"`
+	CacheDir      string `yaml:"cacheDir"`
+	CacheDiskSize int64  `yaml:"cacheDiskSize"`
+}
+
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+func Default() Config {
+	return Config{
+		Addr:         "localhost:9090",
+		MaxBodySize:  3000000000,
+		MaxPixels:    100000000,
+		MemoryBudget: 4 << 30, //4 GiB
+		CORSOrigins:  []string{"*"},
+		LogLevel:     "info",
+
+		RequestTimeout:  5 * time.Minute,
+		ShutdownTimeout: 30 * time.Second,
+		MemoryWait:      10 * time.Second,
+
+		JobWorkers:   2,
+		JobQueueSize: 100,
+		JobTTL:       time.Hour,
+
+		BatchWorkers:      runtime.NumCPU(),
+		BatchMaxFiles:     1000,
+		BatchMaxFileSize:  100 << 20, //100 MiB
+		BatchMaxTotalSize: 1 << 30,   //1 GiB
+
+		SessionHistory:     20,
+		SessionIdleTimeout: 10 * time.Minute,
+
+		RateBurst: 10,
+
+		CacheSize:     256 << 20, //256 MiB
+		CacheDiskSize: 1 << 30,   //1 GiB
+	}
+}
+
+func (cfg Config) UsesTLS() bool {
+	return cfg.TLSCert != ""
+}
+
+func (cfg Config) CacheEnabled() bool {
+	return cfg.CacheSize > 0 || cfg.CacheDir != ""
+}
+
+func (cfg Config) AuthEnabled() bool {
+	return len(cfg.APIKeys) > 0 || cfg.AuthSecret != ""
+}
+
+//lista vazia significa que todos os endpoints estão ligados
+
+func (cfg Config) EndpointEnabled(name string) bool {
+	if len(cfg.EnabledEndpoints) == 0 {
+		return true
+	}
+
+	for _, enabled := range cfg.EnabledEndpoints {
+		if enabled == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (cfg Config) Validate() error {
+	if cfg.Addr == "" {
+		return errors.New("addr must not be empty")
+	}
+
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return errors.New("tls-cert and tls-key must be set together")
+	}
+
+	for _, path := range []string{cfg.TLSCert, cfg.TLSKey} {
+		if path == "" {
+			continue
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("cannot read TLS file: %v", err)
+		}
+	}
+
+	if cfg.MaxBodySize <= 0 {
+		return errors.New("max-body-size must be greater than 0")
+	}
+
+	if cfg.MaxWidth < 0 || cfg.MaxHeight < 0 || cfg.MaxPixels < 0 {
+		return errors.New("max-width, max-height and max-pixels must not be negative")
+	}
+
+	if cfg.MemoryBudget < 0 {
+		return errors.New("memory-budget must not be negative")
+	}
+
+	if cfg.RequestTimeout < 0 || cfg.ShutdownTimeout < 0 || cfg.MemoryWait < 0 {
+		return errors.New("request-timeout, shutdown-timeout and memory-wait must not be negative")
+	}
+
+	if cfg.JobWorkers < 1 || cfg.JobQueueSize < 1 {
+		return errors.New("job-workers and job-queue-size must be at least 1")
+	}
+
+	if cfg.BatchWorkers < 1 || cfg.BatchMaxFiles < 1 {
+		return errors.New("batch-workers and batch-max-files must be at least 1")
+	}
+
+	if cfg.BatchMaxFileSize <= 0 || cfg.BatchMaxTotalSize <= 0 {
+		return errors.New("batch-max-file-size and batch-max-total-size must be greater than 0")
+	}
+
+	if cfg.JobTTL <= 0 {
+		return errors.New("job-ttl must be greater than 0")
+	}
+
+	if cfg.SessionHistory < 1 {
+		return errors.New("session-history must be at least 1")
+	}
+
+	if cfg.SessionIdleTimeout <= 0 {
+		return errors.New("session-idle-timeout must be greater than 0")
+	}
+
+	for _, apiKey := range cfg.APIKeys {
+		parts := strings.SplitN(apiKey, ":", 2)
+
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return errors.New("api-keys must be a list of client:key pairs")
+		}
+	}
+
+	if cfg.AuthSecret != "" && len(cfg.AuthSecret) < 32 {
+		return errors.New("auth-secret must have at least 32 characters")
+	}
+
+	if cfg.RateLimit < 0 || cfg.ClientConcurrency < 0 {
+		return errors.New("rate-limit and client-concurrency must not be negative")
+	}
+
+	if cfg.RateBurst < 1 {
+		return errors.New("rate-burst must be at least 1")
+	}
+
+	if cfg.CacheSize < 0 {
+		return errors.New("cache-size must not be negative")
+	}
+
+	if cfg.CacheDir != "" && cfg.CacheDiskSize <= 0 {
+		return errors.New("cache-disk-size must be greater than 0 when cache-dir is set")
+	}
+
+	for _, proxy := range cfg.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+
+		if cidrErr != nil && net.ParseIP(proxy) == nil {
+			return errors.New("trusted-proxies must be IP addresses or CIDR ranges, got " + proxy)
+		}
+	}
+
+	if len(cfg.CORSOrigins) == 0 {
+		return errors.New("cors-origins must have at least one origin, use * to allow all")
+	}
+
+	for _, level := range LogLevels {
+		if cfg.LogLevel == level {
+			return nil
+		}
+	}
+
+	return errors.New("log-level must be one of " + strings.Join(LogLevels, ", "))
+}
+
+//cada opção pode vir de uma flag ou de uma variável de ambiente com o mesmo nome
+
+type setting struct {
+	name  string
+	usage string
+	apply func(cfg *Config, value string) error
+}
+
+func parseInt(name string, value string) (int64, error) {
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errors.New(name + " must be an integer")
+	}
+
+	return parsed, nil
+}
+
+func parseFloat(name string, value string) (float64, error) {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, errors.New(name + " must be a number")
+	}
+
+	return parsed, nil
+}
+
+func parseDuration(name string, value string) (time.Duration, error) {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.New(name + " must be a duration like 30s or 5m")
+	}
+
+	return parsed, nil
+}
+
+func parseList(value string) []string {
+	list := []string{}
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+var settings = []setting{
+	{"addr", "address to listen on", func(cfg *Config, value string) error {
+		cfg.Addr = value
+		return nil
+	}},
+	{"tls-cert", "TLS certificate file, enables HTTPS together with tls-key", func(cfg *Config, value string) error {
+		cfg.TLSCert = value
+		return nil
+	}},
+	{"tls-key", "TLS private key file", func(cfg *Config, value string) error {
+		cfg.TLSKey = value
+		return nil
+	}},
+	{"max-body-size", "maximum request body size in bytes", func(cfg *Config, value string) error {
+		size, err := parseInt("max-body-size", value)
+		cfg.MaxBodySize = size
+		return err
+	}},
+	{"max-width", "maximum image width in pixels, 0 for no limit", func(cfg *Config, value string) error {
+		width, err := parseInt("max-width", value)
+		cfg.MaxWidth = int(width)
+		return err
+	}},
+	{"max-height", "maximum image height in pixels, 0 for no limit", func(cfg *Config, value string) error {
+		height, err := parseInt("max-height", value)
+		cfg.MaxHeight = int(height)
+		return err
+	}},
+	{"max-pixels", "maximum width times height, summed over animation frames, 0 for no limit", func(cfg *Config, value string) error {
+		pixels, err := parseInt("max-pixels", value)
+		cfg.MaxPixels = pixels
+		return err
+	}},
+	{"memory-budget", "estimated bytes all requests together may use while processing, 0 for no limit", func(cfg *Config, value string) error {
+		budget, err := parseInt("memory-budget", value)
+		cfg.MemoryBudget = budget
+		return err
+	}},
+	{"memory-wait", "how long a request waits for free memory before being rejected, 0 to reject at once", func(cfg *Config, value string) error {
+		wait, err := parseDuration("memory-wait", value)
+		cfg.MemoryWait = wait
+		return err
+	}},
+	{"job-workers", "how many jobs run at the same time", func(cfg *Config, value string) error {
+		workers, err := parseInt("job-workers", value)
+		cfg.JobWorkers = int(workers)
+		return err
+	}},
+	{"job-queue-size", "how many jobs may wait for a worker", func(cfg *Config, value string) error {
+		size, err := parseInt("job-queue-size", value)
+		cfg.JobQueueSize = int(size)
+		return err
+	}},
+	{"job-ttl", "how long finished jobs and their results are kept", func(cfg *Config, value string) error {
+		ttl, err := parseDuration("job-ttl", value)
+		cfg.JobTTL = ttl
+		return err
+	}},
+	{"batch-workers", "how many images of a batch are processed at the same time", func(cfg *Config, value string) error {
+		workers, err := parseInt("batch-workers", value)
+		cfg.BatchWorkers = int(workers)
+		return err
+	}},
+	{"batch-max-files", "how many images a batch may have", func(cfg *Config, value string) error {
+		maxFiles, err := parseInt("batch-max-files", value)
+		cfg.BatchMaxFiles = int(maxFiles)
+		return err
+	}},
+	{"batch-max-file-size", "maximum uncompressed size in bytes of each image in a batch", func(cfg *Config, value string) error {
+		size, err := parseInt("batch-max-file-size", value)
+		cfg.BatchMaxFileSize = size
+		return err
+	}},
+	{"batch-max-total-size", "maximum uncompressed size in bytes of all images in a batch together, and of a ZIP sent in the body or as base64", func(cfg *Config, value string) error {
+		size, err := parseInt("batch-max-total-size", value)
+		cfg.BatchMaxTotalSize = size
+		return err
+	}},
+	{"session-history", "how many edits an editing session keeps for undo", func(cfg *Config, value string) error {
+		history, err := parseInt("session-history", value)
+		cfg.SessionHistory = int(history)
+		return err
+	}},
+	{"session-idle-timeout", "how long an editing session stays open without messages", func(cfg *Config, value string) error {
+		timeout, err := parseDuration("session-idle-timeout", value)
+		cfg.SessionIdleTimeout = timeout
+		return err
+	}},
+	{"cors-origins", "comma separated list of allowed CORS origins", func(cfg *Config, value string) error {
+		cfg.CORSOrigins = parseList(value)
+		return nil
+	}},
+	{"endpoints", "comma separated list of enabled endpoints, empty for all", func(cfg *Config, value string) error {
+		cfg.EnabledEndpoints = parseList(value)
+		return nil
+	}},
+	{"request-timeout", "maximum time a request may take, 0 for no limit", func(cfg *Config, value string) error {
+		timeout, err := parseDuration("request-timeout", value)
+		cfg.RequestTimeout = timeout
+		return err
+	}},
+	{"shutdown-timeout", "how long to wait for running requests when shutting down", func(cfg *Config, value string) error {
+		timeout, err := parseDuration("shutdown-timeout", value)
+		cfg.ShutdownTimeout = timeout
+		return err
+	}},
+	{"log-level", "one of " + strings.Join(LogLevels, ", "), func(cfg *Config, value string) error {
+		cfg.LogLevel = value
+		return nil
+	}},
+	{"cache-size", "bytes of results kept in memory, 0 to keep none", func(cfg *Config, value string) error {
+		size, err := parseInt("cache-size", value)
+		cfg.CacheSize = size
+		return err
+	}},
+	{"cache-dir", "directory where results are also kept, empty to keep them only in memory", func(cfg *Config, value string) error {
+		cfg.CacheDir = value
+		return nil
+	}},
+	{"cache-disk-size", "bytes of results kept in cache-dir", func(cfg *Config, value string) error {
+		size, err := parseInt("cache-disk-size", value)
+		cfg.CacheDiskSize = size
+		return err
+	}},
+	{"api-keys", "comma separated list of client:key pairs accepted in the X-API-Key header", func(cfg *Config, value string) error {
+		cfg.APIKeys = parseList(value)
+		return nil
+	}},
+	{"auth-secret", "secret that signs bearer tokens of the form client.expires.signature", func(cfg *Config, value string) error {
+		cfg.AuthSecret = value
+		return nil
+	}},
+	{"rate-limit", "requests per second allowed for each client, 0 for no limit", func(cfg *Config, value string) error {
+		limit, err := parseFloat("rate-limit", value)
+		cfg.RateLimit = limit
+		return err
+	}},
+	{"rate-burst", "requests a client may make at once before rate-limit applies", func(cfg *Config, value string) error {
+		burst, err := parseInt("rate-burst", value)
+		cfg.RateBurst = int(burst)
+		return err
+	}},
+	{"client-concurrency", "requests each client may have in progress, 0 for no limit", func(cfg *Config, value string) error {
+		concurrency, err := parseInt("client-concurrency", value)
+		cfg.ClientConcurrency = int(concurrency)
+		return err
+	}},
+	{"trusted-proxies", "comma separated list of proxy IPs or CIDR ranges whose X-Forwarded-For is trusted, empty to use the connection address", func(cfg *Config, value string) error {
+		cfg.TrustedProxies = parseList(value)
+		return nil
+	}},
+}
+
+func envName(name string) string {
+	return "IMG_OPS_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func loadFile(cfg *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	//YAML aceita JSON também
+	err = yaml.UnmarshalStrict(content, cfg)
+	if err != nil {
+		return fmt.Errorf("invalid config file %v: %v", path, err)
+	}
+
+	return nil
+}
+
+//prioridade: flags, depois variáveis de ambiente, depois arquivo, depois valores padrão
+
+func Load(args []string) (Config, error) {
+	cfg := Default()
+
+	flags := flag.NewFlagSet("img-ops", flag.ContinueOnError)
+
+	configPath := flags.String("config", os.Getenv(envName("config")), "YAML or JSON config file (env "+envName("config")+")")
+
+	flagValues := map[string]*string{}
+
+	for _, s := range settings {
+		flagValues[s.name] = flags.String(s.name, "", s.usage+" (env "+envName(s.name)+")")
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return cfg, err
+	}
+
+	if *configPath != "" {
+		err = loadFile(&cfg, *configPath)
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	for _, s := range settings {
+		if value, exists := os.LookupEnv(envName(s.name)); exists {
+			err = s.apply(&cfg, value)
+			if err != nil {
+				return cfg, fmt.Errorf("%v: %v", envName(s.name), err)
+			}
+		}
+	}
+
+	setFlags := map[string]bool{}
+
+	flags.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	for _, s := range settings {
+		if setFlags[s.name] {
+			err = s.apply(&cfg, *flagValues[s.name])
+			if err != nil {
+				return cfg, err
+			}
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
@@ -4,8 +4,10 @@ go 1.18
 
 require (
 	github.com/gin-gonic/gin v1.7.7
+	github.com/gorilla/websocket v1.5.0
 	golang.org/x/image v0.0.0-20220413100746-70e8d0d3baa9
 	gonum.org/v1/plot v0.11.0
+	gopkg.in/yaml.v2 v2.2.8
 )
 
 require (
@@ -29,5 +31,4 @@ require (
 	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9 // indirect
 	golang.org/x/sys v0.0.0-20210304124612-50617c2ba197 // indirect
 	golang.org/x/text v0.3.7 // indirect
-	gopkg.in/yaml.v2 v2.2.8 // indirect
 )
@@ -0,0 +1,326 @@
+package imgconversion
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+
+	"img-ops/imgdata"
+	"img-ops/imgerrors"
+)
+
+//parte que lida com GIFs animados, quadro a quadro
+
+type Animation struct {
+	Frames    []*imgdata.Image
+	Delays    []int
+	Disposals []byte
+	LoopCount int
+}
+
+func (animation *Animation) IsAnimated() bool {
+	return len(animation.Frames) > 1
+}
+
+func NewStaticAnimation(img *imgdata.Image) *Animation {
+	return &Animation{
+		Frames:    []*imgdata.Image{img},
+		Delays:    []int{0},
+		Disposals: []byte{gif.DisposalNone},
+	}
+}
+
+func (animation *Animation) Copy() *Animation {
+	frames := make([]*imgdata.Image, len(animation.Frames))
+
+	for i, frame := range animation.Frames {
+		if frame != nil {
+			frames[i] = frame.Copy()
+		}
+	}
+
+	return &Animation{
+		Frames:    frames,
+		Delays:    append([]int{}, animation.Delays...),
+		Disposals: append([]byte{}, animation.Disposals...),
+		LoopCount: animation.LoopCount,
+	}
+}
+
+//cada quadro do GIF é composto sobre o canvas para que todos os quadros tenham a imagem inteira
+
+func composeGIFFrames(decodedGIF *gif.GIF) []*imgdata.Image {
+	bounds := image.Rect(0, 0, decodedGIF.Config.Width, decodedGIF.Config.Height)
+
+	canvas := image.NewNRGBA(bounds)
+
+	frames := make([]*imgdata.Image, len(decodedGIF.Image))
+
+	for i, frame := range decodedGIF.Image {
+		var previous *image.NRGBA
+
+		disposal := byte(gif.DisposalNone)
+
+		if i < len(decodedGIF.Disposal) {
+			disposal = decodedGIF.Disposal[i]
+		}
+
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		frames[i] = imgdata.FromImage(canvas)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return frames
+}
+
+//acompanha os blocos do GIF enquanto o decodificador lê, e cada quadro passa pelo limite de pixels e pelo orçamento antes dos dados dele chegarem ao decodificador
+
+const (
+	gifHeader = iota
+	gifBlock
+	gifSkip
+	gifExtensionLabel
+	gifSubBlockSize
+	gifImageDescriptor
+	gifLZWCodeSize
+	gifTrailer
+)
+
+type gifFrameLimiter struct {
+	reader  io.Reader
+	onFrame func() error
+	err     error
+
+	state      int
+	afterSkip  int
+	remaining  int
+	descriptor []byte
+	header     []byte
+}
+
+func colorTableSize(flags byte) int {
+	if flags&0x80 == 0 {
+		return 0
+	}
+
+	return 3 << ((flags & 0x07) + 1)
+}
+
+func (limiter *gifFrameLimiter) skip(count int, next int) {
+	limiter.state = gifSkip
+	limiter.remaining = count
+	limiter.afterSkip = next
+
+	if count == 0 {
+		limiter.state = next
+	}
+}
+
+//devolve false no byte que começa um quadro recusado, que então não é entregue
+
+func (limiter *gifFrameLimiter) consume(b byte) bool {
+	switch limiter.state {
+	case gifHeader:
+		//assinatura, versão e descritor da tela lógica, o último byte de flags diz se há tabela de cores global
+		limiter.header = append(limiter.header, b)
+
+		if len(limiter.header) == 13 {
+			limiter.skip(colorTableSize(limiter.header[10]), gifBlock)
+		}
+
+	case gifBlock:
+		switch b {
+		case 0x21:
+			limiter.state = gifExtensionLabel
+		case 0x2C:
+			limiter.err = limiter.onFrame()
+			if limiter.err != nil {
+				return false
+			}
+
+			limiter.state = gifImageDescriptor
+			limiter.descriptor = limiter.descriptor[:0]
+		default:
+			limiter.state = gifTrailer
+		}
+
+	case gifExtensionLabel:
+		limiter.state = gifSubBlockSize
+
+	case gifImageDescriptor:
+		limiter.descriptor = append(limiter.descriptor, b)
+
+		if len(limiter.descriptor) == 9 {
+			limiter.skip(colorTableSize(limiter.descriptor[8]), gifLZWCodeSize)
+		}
+
+	case gifLZWCodeSize:
+		limiter.state = gifSubBlockSize
+
+	case gifSubBlockSize:
+		if b == 0 {
+			limiter.state = gifBlock
+		} else {
+			limiter.skip(int(b), gifSubBlockSize)
+		}
+
+	case gifSkip:
+		limiter.remaining--
+
+		if limiter.remaining == 0 {
+			limiter.state = limiter.afterSkip
+		}
+	}
+
+	return true
+}
+
+func (limiter *gifFrameLimiter) Read(p []byte) (int, error) {
+	if limiter.err != nil {
+		return 0, limiter.err
+	}
+
+	n, err := limiter.reader.Read(p)
+
+	for i := 0; i < n; i++ {
+		if !limiter.consume(p[i]) {
+			return i, limiter.err
+		}
+	}
+
+	return n, err
+}
+
+func LoadAnimationWithOptions(data io.Reader, options LoadOptions) (*Animation, *Metadata, error) {
+	stream, err := openImageStream(data, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if stream.format != "gif" {
+		img, metadata, err := stream.decodeImage(options)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return NewStaticAnimation(img), metadata, nil
+	}
+
+	width, height, frames := stream.config.Width, stream.config.Height, 0
+
+	limiter := &gifFrameLimiter{reader: stream.reader, onFrame: func() error {
+		frames++
+
+		err := checkPixelLimit(width, height, frames, options)
+		if err != nil {
+			return err
+		}
+
+		return reserveWorkingSet(width, height, 1, options)
+	}}
+
+	decodedGIF, err := gif.DecodeAll(limiter)
+	if limiter.err != nil {
+		return nil, nil, limiter.err
+	}
+	if err != nil {
+		return nil, nil, decodeError(err)
+	}
+
+	metadata := &Metadata{
+		Format:      stream.format,
+		Width:       decodedGIF.Config.Width,
+		Height:      decodedGIF.Config.Height,
+		Orientation: 1,
+	}
+
+	//um GIF de um quadro só é uma imagem comum, sem compor o quadro na tela lógica
+	if len(decodedGIF.Image) == 1 {
+		bounds := decodedGIF.Image[0].Bounds()
+		metadata.Width = bounds.Dx()
+		metadata.Height = bounds.Dy()
+
+		return NewStaticAnimation(imgdata.FromImage(decodedGIF.Image[0])), metadata, nil
+	}
+
+	animation := &Animation{
+		Frames:    composeGIFFrames(decodedGIF),
+		Delays:    decodedGIF.Delay,
+		Disposals: decodedGIF.Disposal,
+		LoopCount: decodedGIF.LoopCount,
+	}
+
+	return animation, metadata, nil
+}
+
+func LoadAnimation(data io.Reader) (*Animation, error) {
+	animation, _, err := LoadAnimationWithOptions(data, LoadOptions{})
+
+	return animation, err
+}
+
+func makePalettedFrame(img *imgdata.Image, options EncodeOptions) *image.Paletted {
+	src := img.WithDepth(8).ToImage()
+
+	bounds := src.Bounds()
+
+	palette := make(color.Palette, 0, options.Colors)
+
+	if img.HasAlpha() {
+		palette = append(palette, color.Transparent)
+	}
+
+	palette = MedianCutQuantizer{}.Quantize(palette, src)
+
+	frame := image.NewPaletted(bounds, palette)
+
+	var drawer draw.Drawer = draw.Src
+
+	if options.Dither {
+		drawer = draw.FloydSteinberg
+	}
+
+	drawer.Draw(frame, bounds, src, bounds.Min)
+
+	return frame
+}
+
+func EncodeAnimation(writer io.Writer, animation *Animation, options EncodeOptions) error {
+	if options.Colors < 2 || options.Colors > 256 {
+		return imgerrors.InvalidParam("colors", "colors must be between 2 and 256")
+	}
+
+	outputGIF := &gif.GIF{
+		LoopCount: animation.LoopCount,
+	}
+
+	for i, frame := range animation.Frames {
+		delay := 0
+
+		if i < len(animation.Delays) {
+			delay = animation.Delays[i]
+		}
+
+		outputGIF.Image = append(outputGIF.Image, makePalettedFrame(frame, options))
+		outputGIF.Delay = append(outputGIF.Delay, delay)
+
+		//os quadros já estão compostos, então cada um substitui o anterior por inteiro
+		outputGIF.Disposal = append(outputGIF.Disposal, gif.DisposalBackground)
+	}
+
+	return gif.EncodeAll(writer, outputGIF)
+}
@@ -1,73 +1,453 @@
 package imgconversion
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
+	"fmt"
 	"image"
-	"image/color"
-	_ "image/jpeg"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
 	"image/png"
 	"io"
+	"math"
+	"strings"
 
-	_ "golang.org/x/image/bmp"
-	_ "golang.org/x/image/tiff"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+
+	"img-ops/imgdata"
+	"img-ops/imgerrors"
+	"img-ops/imgprocessing"
 )
 
 //parte que lida com conversão de dados
 
+type LoadOptions struct {
+	SkipOrientation bool
+
+	//0 significa sem limite
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int64
+
+	//chamada com a memória estimada antes de decodificar, pode esperar ou recusar
+	Reserve func(bytes int64) error
+}
+
+func limitError(param string, value int64, limit int64) error {
+	return &imgerrors.Error{
+		Code:    imgerrors.CodeTooLarge,
+		Message: fmt.Sprintf("image %v %d exceeds the limit of %d", param, value, limit),
+		Param:   param,
+	}
+}
+
+//erros de decodificação são culpa da imagem enviada
+
+func decodeError(err error) error {
+	if _, typed := imgerrors.As(err); typed {
+		return err
+	}
+
+	if errors.Is(err, image.ErrFormat) {
+		return imgerrors.New(imgerrors.CodeUnsupportedFormat, "unsupported image format, expected png, jpeg, gif, bmp, tiff, pbm, pgm, ppm or pam")
+	}
+
+	return imgerrors.Wrap(imgerrors.CodeInvalidImage, err)
+}
+
+//imagem decodificada, cópia em imgdata e resultado, com até 8 bytes por pixel cada
+
+const workingSetBytesPerPixel = 24
+
+func EstimateWorkingSet(width int, height int, frames int) int64 {
+	return int64(width) * int64(height) * int64(frames) * workingSetBytesPerPixel
+}
+
+func checkPixelLimit(width int, height int, frames int, options LoadOptions) error {
+	pixels := int64(width) * int64(height) * int64(frames)
+
+	if options.MaxPixels > 0 && pixels > options.MaxPixels {
+		return limitError("pixels", pixels, options.MaxPixels)
+	}
+
+	return nil
+}
+
+func reserveWorkingSet(width int, height int, frames int, options LoadOptions) error {
+	if options.Reserve == nil {
+		return nil
+	}
+
+	return options.Reserve(EstimateWorkingSet(width, height, frames))
+}
+
+//lê só o cabeçalho para recusar imagens grandes demais antes de decodificar
+
+func decodeConfigWithLimits(data io.Reader, options LoadOptions) (image.Config, string, error) {
+	config, format, err := image.DecodeConfig(data)
+	if err != nil {
+		return config, format, decodeError(err)
+	}
+
+	if options.MaxWidth > 0 && config.Width > options.MaxWidth {
+		return config, format, limitError("width", int64(config.Width), int64(options.MaxWidth))
+	}
+
+	if options.MaxHeight > 0 && config.Height > options.MaxHeight {
+		return config, format, limitError("height", int64(config.Height), int64(options.MaxHeight))
+	}
+
+	return config, format, checkPixelLimit(config.Width, config.Height, 1, options)
+}
+
+//os metadados de JPEG e PNG ficam antes dos pixels, então basta guardar o começo do arquivo
+
+const metadataPrefixSize = 1 << 20
+
+//guarda o que passa por ele até o limite, para reler o cabeçalho e procurar os metadados sem guardar o arquivo inteiro
+
+type recordingReader struct {
+	reader   io.Reader
+	recorded []byte
+	limit    int
+}
+
+func (reader *recordingReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+
+	if keep := reader.limit - len(reader.recorded); keep > 0 {
+		if keep > n {
+			keep = n
+		}
+
+		reader.recorded = append(reader.recorded, p[:keep]...)
+	}
+
+	return n, err
+}
+
+type imageStream struct {
+	config   image.Config
+	format   string
+	recorder *recordingReader
+
+	//o cabeçalho já lido seguido do resto do arquivo
+	reader io.Reader
+}
+
+func openImageStream(data io.Reader, options LoadOptions) (*imageStream, error) {
+	recorder := &recordingReader{reader: data, limit: math.MaxInt}
+
+	config, format, err := decodeConfigWithLimits(bufio.NewReader(recorder), options)
+	if err != nil {
+		return nil, err
+	}
+
+	header := recorder.recorded
+	recorder.limit = len(header)
+
+	if recorder.limit < metadataPrefixSize {
+		recorder.limit = metadataPrefixSize
+	}
+
+	//no TIFF os metadados podem estar em qualquer lugar do arquivo, então o upload inteiro continua guardado no recorder,
+	//e o decodificador de TIFF ainda faz a própria cópia, já que precisa ler fora de ordem
+	if format == "tiff" {
+		recorder.limit = math.MaxInt
+	}
+
+	return &imageStream{
+		config:   config,
+		format:   format,
+		recorder: recorder,
+		reader:   io.MultiReader(bytes.NewReader(header), recorder),
+	}, nil
+}
+
+func (stream *imageStream) metadata() *Metadata {
+	return ParseMetadata(stream.recorder.recorded, stream.format)
+}
+
+func (stream *imageStream) decodeImage(options LoadOptions) (*imgdata.Image, *Metadata, error) {
+	err := reserveWorkingSet(stream.config.Width, stream.config.Height, 1, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	decodedImg, _, err := image.Decode(stream.reader)
+	if err != nil {
+		return nil, nil, decodeError(err)
+	}
+
+	metadata := stream.metadata()
+
+	bounds := decodedImg.Bounds()
+	metadata.Width = bounds.Dx()
+	metadata.Height = bounds.Dy()
+
+	img := imgdata.FromImage(decodedImg)
+
+	if !options.SkipOrientation && metadata.Orientation != 1 {
+		img = imgprocessing.ApplyOrientation(img, metadata.Orientation)
+
+		metadata.resetOrientation()
+	}
+
+	return img, metadata, nil
+}
+
+//a imagem é decodificada enquanto é lida, sem copiar o arquivo inteiro para a memória antes
+
+func LoadImageWithOptions(data io.Reader, options LoadOptions) (*imgdata.Image, *Metadata, error) {
+	stream, err := openImageStream(data, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return stream.decodeImage(options)
+}
+
+func LoadImage(data io.Reader) (*imgdata.Image, error) {
+	img, _, err := LoadImageWithOptions(data, LoadOptions{})
+
+	return img, err
+}
+
+func LoadMetadata(data io.Reader) (*Metadata, error) {
+	stream, err := openImageStream(data, LoadOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	//lê só até onde os metadados podem estar
+	recorder := stream.recorder
+
+	_, err = io.Copy(io.Discard, io.LimitReader(recorder, int64(recorder.limit-len(recorder.recorded))))
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := stream.metadata()
+	metadata.Width = stream.config.Width
+	metadata.Height = stream.config.Height
+
+	return metadata, nil
+}
+
 func LoadImg(data io.Reader) (*[][][3]uint8, error) {
-	image, _, err := image.Decode(data)
+	img, err := LoadImage(data)
 	if err != nil {
 		return nil, err
 	}
 
-	bounds := image.Bounds()
+	return img.ToMatrix(), nil
+}
 
-	RGBMatrix := [][][3]uint8{}
+func CreateImgFromMatrix(matrix *[][][3]uint8) *image.NRGBA {
+	return imgdata.FromMatrix(matrix).ToNRGBA()
+}
+
+//parte que lida com os formatos de saída
+
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatGIF  = "gif"
+	FormatBMP  = "bmp"
+	FormatTIFF = "tiff"
+)
+
+var contentTypes = map[string]string{
+	FormatPNG:  "image/png",
+	FormatJPEG: "image/jpeg",
+	FormatGIF:  "image/gif",
+	FormatBMP:  "image/bmp",
+	FormatTIFF: "image/tiff",
+	FormatPBM:  "image/x-portable-bitmap",
+	FormatPGM:  "image/x-portable-graymap",
+	FormatPPM:  "image/x-portable-pixmap",
+	FormatPAM:  "image/x-portable-arbitrarymap",
+}
+
+var formats = []string{FormatPNG, FormatJPEG, FormatGIF, FormatBMP, FormatTIFF, FormatPBM, FormatPGM, FormatPPM, FormatPAM}
+
+func Formats() []string {
+	return append([]string{}, formats...)
+}
+
+var formatAliases = map[string]string{
+	"jpg": FormatJPEG,
+	"tif": FormatTIFF,
+	"pnm": FormatPPM,
+}
+
+type EncodeOptions struct {
+	Format          string
+	Quality         int
+	Colors          int
+	Dither          bool
+	TIFFCompression string
+	ASCII           bool
+	Exif            []byte
+}
+
+func DefaultEncodeOptions() EncodeOptions {
+	return EncodeOptions{
+		Format:          FormatPNG,
+		Quality:         jpeg.DefaultQuality,
+		Colors:          256,
+		Dither:          true,
+		TIFFCompression: "deflate",
+	}
+}
+
+func ParseFormat(name string) (string, error) {
+	name = strings.ToLower(name)
+
+	if alias, ok := formatAliases[name]; ok {
+		name = alias
+	}
 
-	for x := bounds.Min.X; x < bounds.Max.X; x++ {
-		newX := [][3]uint8{}
+	if _, ok := contentTypes[name]; !ok {
+		return "", imgerrors.InvalidParam("format", "format must be one of png, jpeg, gif, bmp, tiff, pbm, pgm, ppm or pam")
+	}
 
-		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			r, g, b, _ := image.At(x, y).RGBA()
+	return name, nil
+}
 
-			newY := [3]uint8{uint8(r / 257), uint8(g / 257), uint8(b / 257)}
+func FormatFromContentType(contentType string) (string, bool) {
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
 
-			newX = append(newX, newY)
+	for format, formatContentType := range contentTypes {
+		if formatContentType == contentType {
+			return format, true
 		}
+	}
 
-		RGBMatrix = append(RGBMatrix, newX)
+	if contentType == "image/jpg" {
+		return FormatJPEG, true
 	}
 
-	return &RGBMatrix, nil
+	return "", false
 }
 
-func CreateImgFromMatrix(matrix *[][][3]uint8) *image.NRGBA {
-	width := len(*matrix)
-	height := len((*matrix)[0])
+func ContentTypeOf(format string) string {
+	return contentTypes[format]
+}
+
+func tiffCompressionOf(name string) (tiff.CompressionType, error) {
+	switch name {
+	case "", "deflate":
+		return tiff.Deflate, nil
+	case "none":
+		return tiff.Uncompressed, nil
+	}
+
+	return tiff.Uncompressed, imgerrors.InvalidParam("compression", "compression must be one of none or deflate")
+}
+
+var white = [3]uint8{255, 255, 255}
+
+func EncodeImage(writer io.Writer, img *imgdata.Image, options EncodeOptions) error {
+	switch options.Format {
+	case FormatPNG:
+		if len(options.Exif) > 0 {
+			buf := new(bytes.Buffer)
 
-	upLeft := image.Point{0, 0}
-	lowRight := image.Point{width, height}
+			err := png.Encode(buf, img.ToImage())
+			if err != nil {
+				return err
+			}
+
+			return writePNGWithExif(writer, buf.Bytes(), options.Exif)
+		}
+
+		return png.Encode(writer, img.ToImage())
+
+	case FormatJPEG:
+		if options.Quality < 1 || options.Quality > 100 {
+			return imgerrors.InvalidParam("quality", "quality must be between 1 and 100")
+		}
+
+		flatImg := imgprocessing.FlattenImageAlpha(img, white)
+
+		if len(options.Exif) > 0 {
+			buf := new(bytes.Buffer)
+
+			err := jpeg.Encode(buf, flatImg.ToImage(), &jpeg.Options{Quality: options.Quality})
+			if err != nil {
+				return err
+			}
+
+			return writeJPEGWithExif(writer, buf.Bytes(), options.Exif)
+		}
 
-	img := image.NewNRGBA(image.Rectangle{upLeft, lowRight})
+		return jpeg.Encode(writer, flatImg.ToImage(), &jpeg.Options{Quality: options.Quality})
 
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			img.Set(x, y, color.RGBA{(*matrix)[x][y][0], (*matrix)[x][y][1], (*matrix)[x][y][2], 255})
+	case FormatGIF:
+		if options.Colors < 2 || options.Colors > 256 {
+			return imgerrors.InvalidParam("colors", "colors must be between 2 and 256")
 		}
+
+		return gif.Encode(writer, img.WithDepth(8).ToImage(), makeGIFOptions(options))
+
+	case FormatBMP:
+		return bmp.Encode(writer, img.WithDepth(8).ToImage())
+
+	case FormatTIFF:
+		compression, err := tiffCompressionOf(options.TIFFCompression)
+		if err != nil {
+			return err
+		}
+
+		return tiff.Encode(writer, img.ToImage(), &tiff.Options{Compression: compression})
+
+	case FormatPBM, FormatPGM, FormatPPM, FormatPAM:
+		return EncodeNetpbm(writer, img, options.Format, options.ASCII)
 	}
 
-	return img
+	return imgerrors.InvalidParam("format", "unsupported output format "+options.Format)
 }
 
-func CreatePNGBufferFromMatrix(matrix *[][][3]uint8) (*bytes.Buffer, error) {
-	img := CreateImgFromMatrix(matrix)
+func makeGIFOptions(options EncodeOptions) *gif.Options {
+	var drawer draw.Drawer = draw.Src
+
+	if options.Dither {
+		drawer = draw.FloydSteinberg
+	}
+
+	return &gif.Options{
+		NumColors: options.Colors,
+		Quantizer: MedianCutQuantizer{},
+		Drawer:    drawer,
+	}
+}
 
+func CreateBufferFromImage(img *imgdata.Image, options EncodeOptions) (*bytes.Buffer, error) {
 	buf := new(bytes.Buffer)
 
-	err := png.Encode(buf, img)
+	err := EncodeImage(buf, img, options)
 	if err != nil {
 		return nil, err
 	}
 
 	return buf, nil
 }
+
+func CreatePNGBufferFromImage(img *imgdata.Image) (*bytes.Buffer, error) {
+	return CreateBufferFromImage(img, DefaultEncodeOptions())
+}
+
+func CreateTIFFBufferFromImage(img *imgdata.Image) (*bytes.Buffer, error) {
+	options := DefaultEncodeOptions()
+	options.Format = FormatTIFF
+
+	return CreateBufferFromImage(img, options)
+}
+
+func CreatePNGBufferFromMatrix(matrix *[][][3]uint8) (*bytes.Buffer, error) {
+	return CreatePNGBufferFromImage(imgdata.FromMatrix(matrix))
+}
@@ -0,0 +1,368 @@
+package imgconversion
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+//parte que lida com metadados EXIF e perfis ICC
+
+type Metadata struct {
+	Format        string `json:"format"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	Orientation   int    `json:"orientation"`
+	CaptureTime   string `json:"captureTime,omitempty"`
+	CameraMake    string `json:"cameraMake,omitempty"`
+	CameraModel   string `json:"cameraModel,omitempty"`
+	HasICCProfile bool   `json:"hasICCProfile"`
+	HasExif       bool   `json:"hasExif"`
+
+	exif              []byte
+	orientationOffset int
+}
+
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagICCProfile       = 0x8773
+	tagDateTimeOriginal = 0x9003
+)
+
+var errInvalidExif = errors.New("exif: invalid data")
+
+//um EXIF normal tem no máximo o IFD0 apontando para o IFD do EXIF, mais que isso só aparece em arquivos montados para travar o servidor
+
+const maxIFDDepth = 4
+
+var errExifLoop = errors.New("exif: IFDs point to each other or are nested too deep")
+
+type tiffReader struct {
+	data      []byte
+	byteOrder binary.ByteOrder
+}
+
+func (reader tiffReader) uint16At(offset int) (uint16, error) {
+	if offset < 0 || offset+2 > len(reader.data) {
+		return 0, errInvalidExif
+	}
+
+	return reader.byteOrder.Uint16(reader.data[offset:]), nil
+}
+
+func (reader tiffReader) uint32At(offset int) (uint32, error) {
+	if offset < 0 || offset+4 > len(reader.data) {
+		return 0, errInvalidExif
+	}
+
+	return reader.byteOrder.Uint32(reader.data[offset:]), nil
+}
+
+func (reader tiffReader) asciiAt(entryOffset int, count int) string {
+	valueOffset := entryOffset + 8
+
+	if count > 4 {
+		offset, err := reader.uint32At(entryOffset + 8)
+		if err != nil {
+			return ""
+		}
+		valueOffset = int(offset)
+	}
+
+	if valueOffset < 0 || valueOffset+count > len(reader.data) {
+		return ""
+	}
+
+	return strings.TrimRight(string(reader.data[valueOffset:valueOffset+count]), "\x00 ")
+}
+
+func (reader tiffReader) readIFD(offset int, metadata *Metadata, visited map[int]bool) error {
+	if visited[offset] || len(visited) >= maxIFDDepth {
+		return errExifLoop
+	}
+
+	visited[offset] = true
+
+	entryCount, err := reader.uint16At(offset)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < int(entryCount); i++ {
+		entryOffset := offset + 2 + i*12
+
+		tag, err := reader.uint16At(entryOffset)
+		if err != nil {
+			return err
+		}
+
+		count, err := reader.uint32At(entryOffset + 4)
+		if err != nil {
+			return err
+		}
+
+		switch tag {
+		case tagOrientation:
+			orientation, err := reader.uint16At(entryOffset + 8)
+			if err != nil {
+				return err
+			}
+
+			metadata.Orientation = int(orientation)
+			metadata.orientationOffset = entryOffset + 8
+
+		case tagMake:
+			metadata.CameraMake = reader.asciiAt(entryOffset, int(count))
+
+		case tagModel:
+			metadata.CameraModel = reader.asciiAt(entryOffset, int(count))
+
+		case tagDateTime:
+			if metadata.CaptureTime == "" {
+				metadata.CaptureTime = reader.asciiAt(entryOffset, int(count))
+			}
+
+		case tagDateTimeOriginal:
+			metadata.CaptureTime = reader.asciiAt(entryOffset, int(count))
+
+		case tagICCProfile:
+			metadata.HasICCProfile = true
+
+		case tagExifIFD:
+			exifOffset, err := reader.uint32At(entryOffset + 8)
+			if err != nil {
+				return err
+			}
+
+			err = reader.readIFD(int(exifOffset), metadata, visited)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseTIFFMetadata(data []byte, metadata *Metadata) error {
+	if len(data) < 8 {
+		return errInvalidExif
+	}
+
+	reader := tiffReader{data: data}
+
+	switch string(data[:2]) {
+	case "II":
+		reader.byteOrder = binary.LittleEndian
+	case "MM":
+		reader.byteOrder = binary.BigEndian
+	default:
+		return errInvalidExif
+	}
+
+	ifdOffset, err := reader.uint32At(4)
+	if err != nil {
+		return err
+	}
+
+	return reader.readIFD(int(ifdOffset), metadata, map[int]bool{})
+}
+
+//um EXIF mal formado ou com laço entre IFDs é ignorado, a imagem continua valendo sem ele
+
+func parseExif(data []byte, metadata *Metadata) {
+	exifMetadata := Metadata{}
+
+	err := parseTIFFMetadata(data, &exifMetadata)
+	if err != nil {
+		return
+	}
+
+	metadata.HasExif = true
+	metadata.Orientation = exifMetadata.Orientation
+	metadata.CaptureTime = exifMetadata.CaptureTime
+	metadata.CameraMake = exifMetadata.CameraMake
+	metadata.CameraModel = exifMetadata.CameraModel
+	metadata.HasICCProfile = metadata.HasICCProfile || exifMetadata.HasICCProfile
+	metadata.exif = data
+	metadata.orientationOffset = exifMetadata.orientationOffset
+}
+
+//num TIFF os campos ficam no próprio arquivo, então só eles são lidos e o arquivo inteiro não é repassado como EXIF
+
+func parseTIFFFields(data []byte, metadata *Metadata) {
+	tiffMetadata := Metadata{}
+
+	err := parseTIFFMetadata(data, &tiffMetadata)
+	if err != nil {
+		return
+	}
+
+	metadata.Orientation = tiffMetadata.Orientation
+	metadata.CaptureTime = tiffMetadata.CaptureTime
+	metadata.CameraMake = tiffMetadata.CameraMake
+	metadata.CameraModel = tiffMetadata.CameraModel
+	metadata.HasICCProfile = tiffMetadata.HasICCProfile
+}
+
+func parseJPEGMetadata(data []byte, metadata *Metadata) {
+	offset := 2
+
+	for offset+4 <= len(data) {
+		if data[offset] != 0xFF {
+			return
+		}
+
+		marker := data[offset+1]
+
+		//SOS e EOI marcam o fim dos segmentos de metadados
+		if marker == 0xDA || marker == 0xD9 {
+			return
+		}
+
+		segmentLength := int(binary.BigEndian.Uint16(data[offset+2:]))
+		segmentEnd := offset + 2 + segmentLength
+
+		if segmentLength < 2 || segmentEnd > len(data) {
+			return
+		}
+
+		segment := data[offset+4 : segmentEnd]
+
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			parseExif(segment[6:], metadata)
+		}
+
+		if marker == 0xE2 && bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00")) {
+			metadata.HasICCProfile = true
+		}
+
+		offset = segmentEnd
+	}
+}
+
+func parsePNGMetadata(data []byte, metadata *Metadata) {
+	offset := 8
+
+	for offset+8 <= len(data) {
+		chunkLength := int(binary.BigEndian.Uint32(data[offset:]))
+		chunkType := string(data[offset+4 : offset+8])
+		chunkEnd := offset + 12 + chunkLength
+
+		if chunkLength < 0 || chunkEnd > len(data) {
+			return
+		}
+
+		switch chunkType {
+		case "eXIf":
+			parseExif(data[offset+8:offset+8+chunkLength], metadata)
+		case "iCCP":
+			metadata.HasICCProfile = true
+		case "IDAT", "IEND":
+			return
+		}
+
+		offset = chunkEnd
+	}
+}
+
+func ParseMetadata(data []byte, format string) *Metadata {
+	metadata := &Metadata{Format: format, Orientation: 1}
+
+	switch format {
+	case "jpeg":
+		parseJPEGMetadata(data, metadata)
+	case "png":
+		parsePNGMetadata(data, metadata)
+	case "tiff":
+		parseTIFFFields(data, metadata)
+	}
+
+	if metadata.Orientation < 1 || metadata.Orientation > 8 {
+		metadata.Orientation = 1
+	}
+
+	return metadata
+}
+
+//parte que reescreve os metadados EXIF na saída
+
+func (metadata *Metadata) Exif() []byte {
+	return metadata.exif
+}
+
+func (metadata *Metadata) resetOrientation() {
+	if metadata.orientationOffset == 0 {
+		return
+	}
+
+	exif := make([]byte, len(metadata.exif))
+	copy(exif, metadata.exif)
+
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+
+	if string(exif[:2]) == "MM" {
+		byteOrder = binary.BigEndian
+	}
+
+	byteOrder.PutUint16(exif[metadata.orientationOffset:], 1)
+
+	metadata.exif = exif
+}
+
+//o segmento APP1 do JPEG guarda no máximo 64 KB, e o PNG segue o mesmo limite para não carregar EXIFs maiores que isso
+
+const maxExifLength = 0xFFFF - 2 - 6
+
+func writeJPEGWithExif(writer io.Writer, encoded []byte, exif []byte) error {
+	segmentLength := 2 + 6 + len(exif)
+
+	if len(exif) > maxExifLength || len(encoded) < 2 {
+		_, err := writer.Write(encoded)
+		return err
+	}
+
+	header := []byte{0xFF, 0xD8, 0xFF, 0xE1, uint8(segmentLength >> 8), uint8(segmentLength)}
+	header = append(header, []byte("Exif\x00\x00")...)
+
+	for _, part := range [][]byte{header, exif, encoded[2:]} {
+		_, err := writer.Write(part)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writePNGWithExif(writer io.Writer, encoded []byte, exif []byte) error {
+	//assinatura (8 bytes) mais o chunk IHDR (25 bytes)
+	const ihdrEnd = 33
+
+	if len(exif) > maxExifLength || len(encoded) < ihdrEnd {
+		_, err := writer.Write(encoded)
+		return err
+	}
+
+	chunk := make([]byte, 12+len(exif))
+	binary.BigEndian.PutUint32(chunk, uint32(len(exif)))
+	copy(chunk[4:], "eXIf")
+	copy(chunk[8:], exif)
+	binary.BigEndian.PutUint32(chunk[8+len(exif):], crc32.ChecksumIEEE(chunk[4:8+len(exif)]))
+
+	for _, part := range [][]byte{encoded[:ihdrEnd], chunk, encoded[ihdrEnd:]} {
+		_, err := writer.Write(part)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
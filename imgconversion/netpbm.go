@@ -0,0 +1,549 @@
+package imgconversion
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+
+	"img-ops/imgdata"
+	"img-ops/imgerrors"
+	"img-ops/imgprocessing"
+)
+
+//parte que lida com os formatos Netpbm (PBM, PGM, PPM e PAM)
+
+var errInvalidNetpbm = errors.New("netpbm: invalid header")
+
+type netpbmHeader struct {
+	magic     string
+	width     int
+	height    int
+	depth     int
+	maxValue  int
+	tupleType string
+}
+
+func init() {
+	image.RegisterFormat("pbm", "P1", DecodeNetpbm, DecodeNetpbmConfig)
+	image.RegisterFormat("pbm", "P4", DecodeNetpbm, DecodeNetpbmConfig)
+	image.RegisterFormat("pgm", "P2", DecodeNetpbm, DecodeNetpbmConfig)
+	image.RegisterFormat("pgm", "P5", DecodeNetpbm, DecodeNetpbmConfig)
+	image.RegisterFormat("ppm", "P3", DecodeNetpbm, DecodeNetpbmConfig)
+	image.RegisterFormat("ppm", "P6", DecodeNetpbm, DecodeNetpbmConfig)
+	image.RegisterFormat("pam", "P7", DecodeNetpbm, DecodeNetpbmConfig)
+}
+
+func isNetpbmSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+func skipNetpbmSpace(reader *bufio.Reader) error {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if b == '#' {
+			_, err := reader.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !isNetpbmSpace(b) {
+			return reader.UnreadByte()
+		}
+	}
+}
+
+//lê um token e consome exatamente um caractere de espaço depois dele
+
+func readNetpbmToken(reader *bufio.Reader) (string, error) {
+	err := skipNetpbmSpace(reader)
+	if err != nil {
+		return "", err
+	}
+
+	token := []byte{}
+
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF && len(token) > 0 {
+			return string(token), nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if isNetpbmSpace(b) || b == '#' {
+			if b == '#' {
+				err = reader.UnreadByte()
+			}
+
+			return string(token), err
+		}
+
+		token = append(token, b)
+	}
+}
+
+func readNetpbmInt(reader *bufio.Reader) (int, error) {
+	token, err := readNetpbmToken(reader)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.Atoi(token)
+	if err != nil || value < 0 {
+		return 0, errInvalidNetpbm
+	}
+
+	return value, nil
+}
+
+func readPAMHeader(reader *bufio.Reader, header *netpbmHeader) error {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if fields[0] == "ENDHDR" {
+			return nil
+		}
+
+		if len(fields) < 2 {
+			return errInvalidNetpbm
+		}
+
+		if fields[0] == "TUPLTYPE" {
+			header.tupleType = strings.Join(fields[1:], " ")
+			continue
+		}
+
+		value, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return errInvalidNetpbm
+		}
+
+		switch fields[0] {
+		case "WIDTH":
+			header.width = value
+		case "HEIGHT":
+			header.height = value
+		case "DEPTH":
+			header.depth = value
+		case "MAXVAL":
+			header.maxValue = value
+		}
+	}
+}
+
+func readNetpbmHeader(reader *bufio.Reader) (netpbmHeader, error) {
+	header := netpbmHeader{}
+
+	magic := make([]byte, 2)
+
+	_, err := io.ReadFull(reader, magic)
+	if err != nil {
+		return header, err
+	}
+
+	header.magic = string(magic)
+
+	switch header.magic {
+	case "P7":
+		err = readPAMHeader(reader, &header)
+		if err != nil {
+			return header, err
+		}
+
+	case "P1", "P4", "P2", "P5", "P3", "P6":
+		header.width, err = readNetpbmInt(reader)
+		if err != nil {
+			return header, err
+		}
+
+		header.height, err = readNetpbmInt(reader)
+		if err != nil {
+			return header, err
+		}
+
+		header.depth = 1
+		header.maxValue = 1
+
+		if header.magic == "P3" || header.magic == "P6" {
+			header.depth = 3
+		}
+
+		if header.magic != "P1" && header.magic != "P4" {
+			header.maxValue, err = readNetpbmInt(reader)
+			if err != nil {
+				return header, err
+			}
+		}
+
+	default:
+		return header, errInvalidNetpbm
+	}
+
+	if header.width <= 0 || header.height <= 0 || header.depth < 1 || header.depth > 4 || header.maxValue < 1 || header.maxValue > 65535 {
+		return header, errInvalidNetpbm
+	}
+
+	return header, nil
+}
+
+func (header netpbmHeader) hasAlpha() bool {
+	return header.depth == 2 || header.depth == 4
+}
+
+func (header netpbmHeader) imageDepth() int {
+	if header.maxValue > 255 {
+		return 16
+	}
+
+	return 8
+}
+
+func (header netpbmHeader) colorModel() color.Model {
+	gray := header.depth <= 2
+	highBitDepth := header.imageDepth() == 16
+
+	switch {
+	case gray && !header.hasAlpha() && highBitDepth:
+		return color.Gray16Model
+	case gray && !header.hasAlpha():
+		return color.GrayModel
+	case highBitDepth:
+		return color.NRGBA64Model
+	}
+
+	return color.NRGBAModel
+}
+
+func DecodeNetpbmConfig(data io.Reader) (image.Config, error) {
+	header, err := readNetpbmHeader(bufio.NewReader(data))
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{
+		ColorModel: header.colorModel(),
+		Width:      header.width,
+		Height:     header.height,
+	}, nil
+}
+
+func readNetpbmSamples(reader *bufio.Reader, header netpbmHeader, samples []uint16) error {
+	switch header.magic {
+	case "P1":
+		for i := range samples {
+			err := skipNetpbmSpace(reader)
+			if err != nil {
+				return err
+			}
+
+			b, err := reader.ReadByte()
+			if err != nil {
+				return err
+			}
+
+			if b != '0' && b != '1' {
+				return errors.New("netpbm: invalid bitmap value")
+			}
+
+			samples[i] = uint16(b - '0')
+		}
+
+	case "P2", "P3":
+		for i := range samples {
+			value, err := readNetpbmInt(reader)
+			if err != nil {
+				return err
+			}
+
+			samples[i] = uint16(value)
+		}
+
+	case "P4":
+		rowSize := (header.width + 7) / 8
+		row := make([]byte, rowSize)
+
+		for y := 0; y < header.height; y++ {
+			_, err := io.ReadFull(reader, row)
+			if err != nil {
+				return err
+			}
+
+			for x := 0; x < header.width; x++ {
+				samples[y*header.width+x] = uint16(row[x/8]>>(7-uint(x%8))) & 1
+			}
+		}
+
+	default:
+		bytesPerSample := 1
+
+		if header.maxValue > 255 {
+			bytesPerSample = 2
+		}
+
+		buf := make([]byte, len(samples)*bytesPerSample)
+
+		_, err := io.ReadFull(reader, buf)
+		if err != nil {
+			return err
+		}
+
+		for i := range samples {
+			if bytesPerSample == 2 {
+				samples[i] = uint16(buf[i*2])<<8 | uint16(buf[i*2+1])
+			} else {
+				samples[i] = uint16(buf[i])
+			}
+		}
+	}
+
+	return nil
+}
+
+func DecodeNetpbm(data io.Reader) (image.Image, error) {
+	reader := bufio.NewReader(data)
+
+	header, err := readNetpbmHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]uint16, header.width*header.height*header.depth)
+
+	err = readNetpbmSamples(reader, header, samples)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, imgerrors.Newf(imgerrors.CodeDimensionMismatch, "netpbm: pixel data is shorter than the %dx%d in the header", header.width, header.height)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	img := imgdata.NewImageWithDepth(header.width, header.height, header.depth, header.imageDepth())
+
+	maxValue := uint32(header.maxValue)
+
+	for i, sample := range samples {
+		if uint32(sample) > maxValue {
+			return nil, errors.New("netpbm: sample exceeds maxval")
+		}
+
+		value := uint32(sample) * imgdata.MaxSample / maxValue
+
+		//no PBM o valor 1 representa preto
+		if header.magic == "P1" || header.magic == "P4" {
+			value = imgdata.MaxSample - value
+		}
+
+		pixelIndex := i / header.depth
+
+		img.SetSample(pixelIndex%header.width, pixelIndex/header.width, i%header.depth, uint16(value))
+	}
+
+	return img.ToImage(), nil
+}
+
+//parte que escreve os formatos Netpbm
+
+const (
+	FormatPBM = "pbm"
+	FormatPGM = "pgm"
+	FormatPPM = "ppm"
+	FormatPAM = "pam"
+)
+
+func writeNetpbmSample(writer *bufio.Writer, value uint16, maxValue int, ascii bool) error {
+	var err error
+
+	if ascii {
+		_, err = writer.WriteString(strconv.Itoa(int(value)) + "\n")
+		return err
+	}
+
+	if maxValue > 255 {
+		err = writer.WriteByte(uint8(value >> 8))
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.WriteByte(uint8(value))
+}
+
+func encodePBM(writer *bufio.Writer, img *imgdata.Image, ascii bool) error {
+	grayImg := imgprocessing.ConvertImageToGrayscale(imgprocessing.FlattenImageAlpha(img, white))
+
+	magic := "P4"
+
+	if ascii {
+		magic = "P1"
+	}
+
+	_, err := fmt.Fprintf(writer, "%s\n%d %d\n", magic, img.Width, img.Height)
+	if err != nil {
+		return err
+	}
+
+	for y := 0; y < img.Height; y++ {
+		var packed byte
+
+		for x := 0; x < img.Width; x++ {
+			var bit byte = 0
+
+			if grayImg.Sample(x, y, 0) < imgdata.MaxSample/2 {
+				bit = 1
+			}
+
+			if ascii {
+				err = writer.WriteByte('0' + bit)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			packed |= bit << (7 - uint(x%8))
+
+			if x%8 == 7 || x == img.Width-1 {
+				err = writer.WriteByte(packed)
+				if err != nil {
+					return err
+				}
+
+				packed = 0
+			}
+		}
+
+		if ascii {
+			err = writer.WriteByte('\n')
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func encodePGMOrPPM(writer *bufio.Writer, img *imgdata.Image, format string, ascii bool) error {
+	flatImg := imgprocessing.FlattenImageAlpha(img, white)
+
+	var magic string
+
+	if format == FormatPPM {
+		flatImg = flatImg.WithColor()
+		magic = "P6"
+
+		if ascii {
+			magic = "P3"
+		}
+	} else {
+		flatImg = imgprocessing.ConvertImageToGrayscale(flatImg)
+		magic = "P5"
+
+		if ascii {
+			magic = "P2"
+		}
+	}
+
+	maxValue := 255
+
+	if img.Depth == 16 {
+		maxValue = imgdata.MaxSample
+	}
+
+	_, err := fmt.Fprintf(writer, "%s\n%d %d\n%d\n", magic, img.Width, img.Height, maxValue)
+	if err != nil {
+		return err
+	}
+
+	for y := 0; y < flatImg.Height; y++ {
+		for x := 0; x < flatImg.Width; x++ {
+			for z := 0; z < flatImg.Channels; z++ {
+				value := flatImg.Sample(x, y, z)
+
+				if maxValue == 255 {
+					value /= 257
+				}
+
+				err = writeNetpbmSample(writer, value, maxValue, ascii)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func encodePAM(writer *bufio.Writer, img *imgdata.Image) error {
+	tupleTypes := []string{"", "GRAYSCALE", "GRAYSCALE_ALPHA", "RGB", "RGB_ALPHA"}
+
+	maxValue := 255
+
+	if img.Depth == 16 {
+		maxValue = imgdata.MaxSample
+	}
+
+	_, err := fmt.Fprintf(writer, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH %d\nMAXVAL %d\nTUPLTYPE %s\nENDHDR\n",
+		img.Width, img.Height, img.Channels, maxValue, tupleTypes[img.Channels])
+	if err != nil {
+		return err
+	}
+
+	for y := 0; y < img.Height; y++ {
+		_, err = writer.Write(img.Pix[img.Offset(0, y) : img.Offset(0, y)+img.Width*img.Channels*img.BytesPerSample()])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func EncodeNetpbm(data io.Writer, img *imgdata.Image, format string, ascii bool) error {
+	writer := bufio.NewWriter(data)
+
+	var err error
+
+	switch format {
+	case FormatPBM:
+		err = encodePBM(writer, img, ascii)
+	case FormatPGM, FormatPPM:
+		err = encodePGMOrPPM(writer, img, format, ascii)
+	case FormatPAM:
+		if ascii {
+			return imgerrors.InvalidParam("ascii", "pam has no ascii variant")
+		}
+
+		err = encodePAM(writer, img)
+	default:
+		return imgerrors.InvalidParam("format", "unsupported netpbm format "+format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
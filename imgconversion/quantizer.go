@@ -0,0 +1,132 @@
+package imgconversion
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+//quantização de paleta por corte mediano, usada na saída em GIF
+
+const maxQuantizerSamples = 1 << 16
+
+type MedianCutQuantizer struct{}
+
+type colorBox struct {
+	colors [][3]uint8
+}
+
+func (box *colorBox) widestChannel() (int, int) {
+	widestChannel := 0
+	widestRange := -1
+
+	for z := 0; z < 3; z++ {
+		minValue, maxValue := 255, 0
+
+		for _, c := range box.colors {
+			value := int(c[z])
+
+			if value < minValue {
+				minValue = value
+			}
+
+			if value > maxValue {
+				maxValue = value
+			}
+		}
+
+		if maxValue-minValue > widestRange {
+			widestChannel = z
+			widestRange = maxValue - minValue
+		}
+	}
+
+	return widestChannel, widestRange
+}
+
+func (box *colorBox) average() color.RGBA {
+	var sum [3]int
+
+	for _, c := range box.colors {
+		for z := 0; z < 3; z++ {
+			sum[z] += int(c[z])
+		}
+	}
+
+	count := len(box.colors)
+
+	return color.RGBA{uint8(sum[0] / count), uint8(sum[1] / count), uint8(sum[2] / count), 255}
+}
+
+func sampleColors(m image.Image) [][3]uint8 {
+	bounds := m.Bounds()
+
+	step := 1
+
+	for (bounds.Dx()/step)*(bounds.Dy()/step) > maxQuantizerSamples {
+		step++
+	}
+
+	colors := [][3]uint8{}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			c := color.RGBAModel.Convert(m.At(x, y)).(color.RGBA)
+
+			colors = append(colors, [3]uint8{c.R, c.G, c.B})
+		}
+	}
+
+	return colors
+}
+
+func (q MedianCutQuantizer) Quantize(p color.Palette, m image.Image) color.Palette {
+	numColors := cap(p) - len(p)
+
+	colors := sampleColors(m)
+
+	if len(colors) == 0 || numColors <= 0 {
+		return p
+	}
+
+	boxes := []*colorBox{{colors: colors}}
+
+	for len(boxes) < numColors {
+		boxIndex := -1
+		boxChannel := 0
+		boxRange := 0
+
+		for i, box := range boxes {
+			if len(box.colors) < 2 {
+				continue
+			}
+
+			channel, channelRange := box.widestChannel()
+
+			if channelRange > boxRange {
+				boxIndex, boxChannel, boxRange = i, channel, channelRange
+			}
+		}
+
+		if boxIndex < 0 {
+			break
+		}
+
+		box := boxes[boxIndex]
+
+		sort.Slice(box.colors, func(i, j int) bool {
+			return box.colors[i][boxChannel] < box.colors[j][boxChannel]
+		})
+
+		median := len(box.colors) / 2
+
+		boxes[boxIndex] = &colorBox{colors: box.colors[:median]}
+		boxes = append(boxes, &colorBox{colors: box.colors[median:]})
+	}
+
+	for _, box := range boxes {
+		p = append(p, box.average())
+	}
+
+	return p
+}
@@ -0,0 +1,453 @@
+package imgdata
+
+import (
+	"image"
+	"image/color"
+
+	"img-ops/imgerrors"
+)
+
+//parte que define a representação das imagens em memória
+
+type AlphaMode int
+
+const (
+	AlphaPreserve AlphaMode = iota
+	AlphaFlatten
+	AlphaChannel
+)
+
+func ParseAlphaMode(name string) (AlphaMode, error) {
+	switch name {
+	case "", "preserve":
+		return AlphaPreserve, nil
+	case "flatten":
+		return AlphaFlatten, nil
+	case "channel":
+		return AlphaChannel, nil
+	}
+
+	return AlphaPreserve, imgerrors.InvalidParam("alpha", "alpha must be one of preserve, flatten or channel")
+}
+
+const MaxSample = 65535
+
+type Image struct {
+	Width     int
+	Height    int
+	Stride    int
+	Channels  int
+	Depth     int
+	AlphaMode AlphaMode
+	Pix       []uint8
+}
+
+func NewImage(width int, height int, channels int) *Image {
+	return NewImageWithDepth(width, height, channels, 8)
+}
+
+func NewImageWithDepth(width int, height int, channels int, depth int) *Image {
+	stride := width * channels * depth / 8
+
+	return &Image{
+		Width:    width,
+		Height:   height,
+		Stride:   stride,
+		Channels: channels,
+		Depth:    depth,
+		Pix:      make([]uint8, stride*height),
+	}
+}
+
+func (img *Image) NewBlank(width int, height int) *Image {
+	newImg := NewImageWithDepth(width, height, img.Channels, img.Depth)
+	newImg.AlphaMode = img.AlphaMode
+
+	return newImg
+}
+
+func (img *Image) BytesPerSample() int {
+	return img.Depth / 8
+}
+
+func (img *Image) IsGray() bool {
+	return img.Channels <= 2
+}
+
+func (img *Image) HasAlpha() bool {
+	return img.Channels == 2 || img.Channels == 4
+}
+
+func (img *Image) AlphaIndex() int {
+	return img.Channels - 1
+}
+
+func (img *Image) ColorChannels() int {
+	if img.HasAlpha() {
+		return img.Channels - 1
+	}
+
+	return img.Channels
+}
+
+func (img *Image) OperableChannels() int {
+	if img.AlphaMode == AlphaChannel {
+		return img.Channels
+	}
+
+	return img.ColorChannels()
+}
+
+func (img *Image) Offset(x int, y int) int {
+	return y*img.Stride + x*img.Channels*img.BytesPerSample()
+}
+
+func (img *Image) InBounds(x int, y int) bool {
+	return x >= 0 && y >= 0 && x < img.Width && y < img.Height
+}
+
+//os valores das amostras são sempre expostos na escala de 16 bits, independente da profundidade
+
+func (img *Image) Sample(x int, y int, channel int) uint16 {
+	if img.Depth == 16 {
+		i := img.Offset(x, y) + channel*2
+
+		return uint16(img.Pix[i])<<8 | uint16(img.Pix[i+1])
+	}
+
+	return uint16(img.Pix[img.Offset(x, y)+channel]) * 257
+}
+
+func (img *Image) SetSample(x int, y int, channel int, value uint16) {
+	if img.Depth == 16 {
+		i := img.Offset(x, y) + channel*2
+
+		img.Pix[i] = uint8(value >> 8)
+		img.Pix[i+1] = uint8(value)
+
+		return
+	}
+
+	img.Pix[img.Offset(x, y)+channel] = uint8(value / 257)
+}
+
+func (img *Image) ColorSample(x int, y int, channel int) uint16 {
+	if img.IsGray() {
+		channel = 0
+	}
+
+	return img.Sample(x, y, channel)
+}
+
+func (img *Image) ColorAt(x int, y int, channel int) uint8 {
+	if img.IsGray() {
+		channel = 0
+	}
+
+	return img.At(x, y, channel)
+}
+
+func (img *Image) At(x int, y int, channel int) uint8 {
+	if img.Depth == 16 {
+		return uint8(img.Sample(x, y, channel) / 257)
+	}
+
+	return img.Pix[img.Offset(x, y)+channel]
+}
+
+func (img *Image) Set(x int, y int, channel int, value uint8) {
+	img.SetSample(x, y, channel, uint16(value)*257)
+}
+
+func (img *Image) Pixel(x int, y int) []uint8 {
+	offset := img.Offset(x, y)
+	size := img.Channels * img.BytesPerSample()
+
+	return img.Pix[offset : offset+size : offset+size]
+}
+
+func (img *Image) SetPixel(x int, y int, values []uint8) {
+	copy(img.Pixel(x, y), values)
+}
+
+func (img *Image) Fill(values []uint8) {
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			img.SetPixel(x, y, values)
+		}
+	}
+}
+
+func (img *Image) Copy() *Image {
+	newImg := *img
+
+	newImg.Pix = make([]uint8, len(img.Pix))
+	copy(newImg.Pix, img.Pix)
+
+	return &newImg
+}
+
+func (img *Image) WithAlpha() *Image {
+	if img.HasAlpha() {
+		return img
+	}
+
+	newImg := NewImageWithDepth(img.Width, img.Height, img.Channels+1, img.Depth)
+	newImg.AlphaMode = img.AlphaMode
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			copy(newImg.Pixel(x, y), img.Pixel(x, y))
+
+			newImg.SetSample(x, y, newImg.AlphaIndex(), MaxSample)
+		}
+	}
+
+	return newImg
+}
+
+func (img *Image) WithColor() *Image {
+	if !img.IsGray() {
+		return img
+	}
+
+	newImg := NewImageWithDepth(img.Width, img.Height, img.Channels+2, img.Depth)
+	newImg.AlphaMode = img.AlphaMode
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			grayValue := img.Sample(x, y, 0)
+
+			newImg.SetSample(x, y, 0, grayValue)
+			newImg.SetSample(x, y, 1, grayValue)
+			newImg.SetSample(x, y, 2, grayValue)
+
+			if img.HasAlpha() {
+				newImg.SetSample(x, y, 3, img.Sample(x, y, 1))
+			}
+		}
+	}
+
+	return newImg
+}
+
+func (img *Image) WithDepth(depth int) *Image {
+	if img.Depth == depth {
+		return img
+	}
+
+	newImg := NewImageWithDepth(img.Width, img.Height, img.Channels, depth)
+	newImg.AlphaMode = img.AlphaMode
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			for z := 0; z < img.Channels; z++ {
+				newImg.SetSample(x, y, z, img.Sample(x, y, z))
+			}
+		}
+	}
+
+	return newImg
+}
+
+//conversões de e para image.Image
+
+func isOpaque(src image.Image) bool {
+	opaqueImg, ok := src.(interface{ Opaque() bool })
+
+	return ok && opaqueImg.Opaque()
+}
+
+func isHighBitDepth(src image.Image) bool {
+	switch src.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return true
+	}
+
+	return false
+}
+
+func fromGray(src *image.Gray) *Image {
+	bounds := src.Bounds()
+
+	img := NewImage(bounds.Dx(), bounds.Dy(), 1)
+
+	for y := 0; y < img.Height; y++ {
+		srcStart := src.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+
+		copy(img.Pix[img.Offset(0, y):img.Offset(0, y)+img.Stride], src.Pix[srcStart:srcStart+img.Width])
+	}
+
+	return img
+}
+
+func fromGray16(src *image.Gray16) *Image {
+	bounds := src.Bounds()
+
+	img := NewImageWithDepth(bounds.Dx(), bounds.Dy(), 1, 16)
+
+	for y := 0; y < img.Height; y++ {
+		srcStart := src.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+
+		copy(img.Pix[img.Offset(0, y):img.Offset(0, y)+img.Stride], src.Pix[srcStart:srcStart+img.Width*2])
+	}
+
+	return img
+}
+
+func FromImage(src image.Image) *Image {
+	switch grayImg := src.(type) {
+	case *image.Gray:
+		return fromGray(grayImg)
+	case *image.Gray16:
+		return fromGray16(grayImg)
+	}
+
+	bounds := src.Bounds()
+
+	channels := 3
+
+	if !isOpaque(src) {
+		channels = 4
+	}
+
+	depth := 8
+
+	if isHighBitDepth(src) {
+		depth = 16
+	}
+
+	img := NewImageWithDepth(bounds.Dx(), bounds.Dy(), channels, depth)
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			c := color.NRGBA64Model.Convert(src.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA64)
+
+			img.SetSample(x, y, 0, c.R)
+			img.SetSample(x, y, 1, c.G)
+			img.SetSample(x, y, 2, c.B)
+
+			if channels == 4 {
+				img.SetSample(x, y, 3, c.A)
+			}
+		}
+	}
+
+	return img
+}
+
+func (img *Image) ToNRGBA() *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, img.Width, img.Height))
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			offset := dst.PixOffset(x, y)
+
+			dst.Pix[offset] = img.ColorAt(x, y, 0)
+			dst.Pix[offset+1] = img.ColorAt(x, y, 1)
+			dst.Pix[offset+2] = img.ColorAt(x, y, 2)
+			dst.Pix[offset+3] = 255
+
+			if img.HasAlpha() {
+				dst.Pix[offset+3] = img.At(x, y, img.AlphaIndex())
+			}
+		}
+	}
+
+	return dst
+}
+
+func (img *Image) ToNRGBA64() *image.NRGBA64 {
+	dst := image.NewNRGBA64(image.Rect(0, 0, img.Width, img.Height))
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			c := color.NRGBA64{img.ColorSample(x, y, 0), img.ColorSample(x, y, 1), img.ColorSample(x, y, 2), MaxSample}
+
+			if img.HasAlpha() {
+				c.A = img.Sample(x, y, img.AlphaIndex())
+			}
+
+			dst.SetNRGBA64(x, y, c)
+		}
+	}
+
+	return dst
+}
+
+func (img *Image) ToGray() *image.Gray {
+	dst := image.NewGray(image.Rect(0, 0, img.Width, img.Height))
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			dst.Pix[dst.PixOffset(x, y)] = img.ColorAt(x, y, 0)
+		}
+	}
+
+	return dst
+}
+
+func (img *Image) ToGray16() *image.Gray16 {
+	dst := image.NewGray16(image.Rect(0, 0, img.Width, img.Height))
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			dst.SetGray16(x, y, color.Gray16{img.ColorSample(x, y, 0)})
+		}
+	}
+
+	return dst
+}
+
+func (img *Image) ToImage() image.Image {
+	if img.Channels == 1 && img.Depth == 16 {
+		return img.ToGray16()
+	}
+
+	if img.Channels == 1 {
+		return img.ToGray()
+	}
+
+	if img.Depth == 16 {
+		return img.ToNRGBA64()
+	}
+
+	return img.ToNRGBA()
+}
+
+//adaptadores para o formato antigo de matriz ([x][y][canal])
+
+func FromMatrix(matrix *[][][3]uint8) *Image {
+	width := len(*matrix)
+	height := 0
+
+	if width > 0 {
+		height = len((*matrix)[0])
+	}
+
+	img := NewImage(width, height, 3)
+
+	for x := 0; x < width; x++ {
+		for y := 0; y < height && y < len((*matrix)[x]); y++ {
+			copy(img.Pixel(x, y), (*matrix)[x][y][:])
+		}
+	}
+
+	return img
+}
+
+func (img *Image) ToMatrix() *[][][3]uint8 {
+	matrix := make([][][3]uint8, img.Width)
+
+	for x := 0; x < img.Width; x++ {
+		matrix[x] = make([][3]uint8, img.Height)
+
+		for y := 0; y < img.Height; y++ {
+			for z := 0; z < 3; z++ {
+				matrix[x][y][z] = img.ColorAt(x, y, z)
+			}
+		}
+	}
+
+	return &matrix
+}
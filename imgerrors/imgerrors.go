@@ -0,0 +1,69 @@
+package imgerrors
+
+import (
+	"errors"
+	"fmt"
+)
+
+//parte que define os erros conhecidos, cada um com um código que o servidor traduz para um status HTTP
+
+type Code string
+
+const (
+	CodeInvalidParameter   Code = "invalid_parameter"
+	CodeInvalidImage       Code = "invalid_image"
+	CodeUnsupportedFormat  Code = "unsupported_format"
+	CodeDimensionMismatch  Code = "dimension_mismatch"
+	CodeTooLarge           Code = "too_large"
+	CodeNotFound           Code = "not_found"
+	CodeConflict           Code = "conflict"
+	CodePreconditionFailed Code = "precondition_failed"
+	CodeUnauthorized       Code = "unauthorized"
+	CodeRateLimited        Code = "rate_limited"
+	CodeUnavailable        Code = "unavailable"
+	CodeTimeout            Code = "timeout"
+	CodeInternal           Code = "internal"
+)
+
+type Error struct {
+	Code    Code
+	Message string
+	Param   string
+	Err     error
+}
+
+func (err *Error) Error() string {
+	return err.Message
+}
+
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func Newf(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func InvalidParam(param string, message string) *Error {
+	return &Error{Code: CodeInvalidParameter, Message: message, Param: param}
+}
+
+//guarda o erro original para os logs, a mensagem vem dele
+
+func Wrap(code Code, err error) *Error {
+	return &Error{Code: code, Message: err.Error(), Err: err}
+}
+
+func As(err error) (*Error, bool) {
+	var typedErr *Error
+
+	if errors.As(err, &typedErr) {
+		return typedErr, true
+	}
+
+	return nil, false
+}
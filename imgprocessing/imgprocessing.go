@@ -1,14 +1,20 @@
 package imgprocessing
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"sort"
+
+	"img-ops/imgdata"
 )
 
 //parte que processa as images
 
-func getMaxNum[T int | uint8](num1 T, num2 T) T {
+//todas as operações trabalham com amostras na escala de 16 bits (0 a 65535),
+//imagens de 8 bits são convertidas na leitura e escrita de cada amostra
+
+func getMaxNum[T int | uint8 | uint16](num1 T, num2 T) T {
 	var greatestNum T = 0
 	if num1 > num2 {
 		greatestNum = num1
@@ -18,20 +24,30 @@ func getMaxNum[T int | uint8](num1 T, num2 T) T {
 	return greatestNum
 }
 
-func AddPixels(pixel1 uint8, pixel2 uint8) uint8 {
-	newPixel := pixel1 + pixel2
+func clampSample(value float64) uint16 {
+	if value < 0 {
+		return 0
+	}
 
-	maxPixel := getMaxNum(pixel1, pixel2)
+	if value > imgdata.MaxSample {
+		return imgdata.MaxSample
+	}
 
-	if newPixel > 255 || newPixel < maxPixel {
-		newPixel = 255
+	return uint16(value)
+}
+
+func AddPixels(pixel1 uint16, pixel2 uint16) uint16 {
+	newPixel := uint32(pixel1) + uint32(pixel2)
+
+	if newPixel > imgdata.MaxSample {
+		newPixel = imgdata.MaxSample
 	}
 
-	return newPixel
+	return uint16(newPixel)
 }
 
-func SubtractPixels(pixel1 uint8, pixel2 uint8) uint8 {
-	var newPixel uint8
+func SubtractPixels(pixel1 uint16, pixel2 uint16) uint16 {
+	var newPixel uint16
 
 	if pixel1 > pixel2 {
 		newPixel = pixel1 - pixel2
@@ -42,240 +58,506 @@ func SubtractPixels(pixel1 uint8, pixel2 uint8) uint8 {
 	return newPixel
 }
 
-func blendPixels(factor float32, pixel1 uint8, pixel2 uint8) uint8 {
+func blendPixels(factor float32, pixel1 uint16, pixel2 uint16) uint16 {
 	var newPixel float32 = factor*float32(pixel1) + (1-factor)*float32(pixel2)
 
-	return uint8(newPixel)
+	return clampSample(float64(newPixel))
 }
 
-func BlendPixelsCurry(factor float32) func(pixel1 uint8, pixel2 uint8) uint8 {
-	return func(pixel1, pixel2 uint8) uint8 {
+func BlendPixelsCurry(factor float32) func(pixel1 uint16, pixel2 uint16) uint16 {
+	return func(pixel1, pixel2 uint16) uint16 {
 		return blendPixels(factor, pixel1, pixel2)
 	}
 }
 
-func AvgPixels(pixel1 uint8, pixel2 uint8) uint8 {
-	var newPixel float32 = (float32(pixel1) + float32(pixel2)) / 2
-
-	return uint8(newPixel)
+func AvgPixels(pixel1 uint16, pixel2 uint16) uint16 {
+	return uint16((uint32(pixel1) + uint32(pixel2)) / 2)
 }
 
-func ANDPixels(pixel1 uint8, pixel2 uint8) uint8 {
+func ANDPixels(pixel1 uint16, pixel2 uint16) uint16 {
 	return pixel1 & pixel2
 }
 
-func ORPixels(pixel1 uint8, pixel2 uint8) uint8 {
+func ORPixels(pixel1 uint16, pixel2 uint16) uint16 {
 	return pixel1 | pixel2
 }
 
-func XORPixels(pixel1 uint8, pixel2 uint8) uint8 {
+func XORPixels(pixel1 uint16, pixel2 uint16) uint16 {
 	return pixel1 ^ pixel2
 }
 
-func OperateOnTwoMatrixes(
-	matrix1 *[][][3]uint8,
-	matrix2 *[][][3]uint8,
-	onPixel func(pixel1 uint8, pixel2 uint8) uint8,
-) [][][3]uint8 {
-	maxWidth := getMaxNum(len(*matrix1), len(*matrix2))
-	maxHeight := getMaxNum(len((*matrix1)[0]), len((*matrix2)[0]))
+//adaptadores das operações antigas de 8 bits
+
+func toSampleOperation(onPixel func(pixel uint8) uint8) func(pixel uint16) uint16 {
+	return func(pixel uint16) uint16 {
+		return uint16(onPixel(uint8(pixel/257))) * 257
+	}
+}
 
-	newMatrix := [][][3]uint8{}
+func toTwoSampleOperation(onPixel func(pixel1 uint8, pixel2 uint8) uint8) func(pixel1 uint16, pixel2 uint16) uint16 {
+	return func(pixel1 uint16, pixel2 uint16) uint16 {
+		return uint16(onPixel(uint8(pixel1/257), uint8(pixel2/257))) * 257
+	}
+}
 
-	for x := 0; x < maxWidth; x++ {
-		newX := [][3]uint8{}
+func toSampleFilterOperation(operation func(pixels []float64) uint8) func(pixels []float64) uint16 {
+	return func(pixels []float64) uint16 {
+		for i := range pixels {
+			pixels[i] /= 257
+		}
 
-		for y := 0; y < maxHeight; y++ {
-			newY := [3]uint8{}
+		return uint16(operation(pixels)) * 257
+	}
+}
 
-			for z := 0; z < 3; z++ {
-				var pixel1 uint8 = 0
-				var pixel2 uint8 = 0
+//funções para lidar com o canal alfa
+
+func premultiplyPixel(img *imgdata.Image, x int, y int) {
+	alphaIndex := img.AlphaIndex()
+	alpha := uint32(img.Sample(x, y, alphaIndex))
+
+	for z := 0; z < alphaIndex; z++ {
+		value := (uint32(img.Sample(x, y, z))*alpha + imgdata.MaxSample/2) / imgdata.MaxSample
+
+		img.SetSample(x, y, z, uint16(value))
+	}
+}
+
+func unpremultiplyPixel(img *imgdata.Image, x int, y int) {
+	alphaIndex := img.AlphaIndex()
+	alpha := uint64(img.Sample(x, y, alphaIndex))
+
+	for z := 0; z < alphaIndex; z++ {
+		if alpha == 0 {
+			img.SetSample(x, y, z, 0)
+			continue
+		}
+
+		value := (uint64(img.Sample(x, y, z))*imgdata.MaxSample + alpha/2) / alpha
+
+		if value > imgdata.MaxSample {
+			value = imgdata.MaxSample
+		}
+
+		img.SetSample(x, y, z, uint16(value))
+	}
+}
+
+func premultiplyImage(img *imgdata.Image) *imgdata.Image {
+	newImg := img.Copy()
+
+	for y := 0; y < newImg.Height; y++ {
+		for x := 0; x < newImg.Width; x++ {
+			premultiplyPixel(newImg, x, y)
+		}
+	}
+
+	return newImg
+}
+
+func unpremultiplyImage(img *imgdata.Image) {
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			unpremultiplyPixel(img, x, y)
+		}
+	}
+}
+
+func overAlpha(alpha1 uint16, alpha2 uint16) uint16 {
+	value := uint32(alpha1) + (uint32(alpha2)*(imgdata.MaxSample-uint32(alpha1))+imgdata.MaxSample/2)/imgdata.MaxSample
+
+	return uint16(value)
+}
+
+func usesPremultipliedAlpha(img *imgdata.Image) bool {
+	return img.HasAlpha() && img.AlphaMode == imgdata.AlphaPreserve
+}
+
+func matchFormats(imgs []*imgdata.Image) []*imgdata.Image {
+	hasAlpha := false
+	hasColor := false
+	depth := 8
+
+	for _, img := range imgs {
+		hasAlpha = hasAlpha || img.HasAlpha()
+		hasColor = hasColor || !img.IsGray()
+		depth = getMaxNum(depth, img.Depth)
+	}
+
+	matchedImgs := make([]*imgdata.Image, len(imgs))
+
+	for i, img := range imgs {
+		matchedImgs[i] = img.WithDepth(depth)
+
+		if hasColor {
+			matchedImgs[i] = matchedImgs[i].WithColor()
+		}
+
+		if hasAlpha {
+			matchedImgs[i] = matchedImgs[i].WithAlpha()
+		}
+	}
+
+	return matchedImgs
+}
+
+func FlattenImageAlpha(img *imgdata.Image, background [3]uint8) *imgdata.Image {
+	if !img.HasAlpha() {
+		return img
+	}
 
-				if x < len(*matrix1) && y < len((*matrix1)[0]) {
-					pixel1 = (*matrix1)[x][y][z]
+	colorChannels := img.ColorChannels()
+
+	newImg := imgdata.NewImageWithDepth(img.Width, img.Height, colorChannels, img.Depth)
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			alpha := uint32(img.Sample(x, y, img.AlphaIndex()))
+
+			for z := 0; z < colorChannels; z++ {
+				backgroundSample := uint32(background[z]) * 257
+
+				value := uint32(img.Sample(x, y, z))*alpha/imgdata.MaxSample + backgroundSample*(imgdata.MaxSample-alpha)/imgdata.MaxSample
+
+				newImg.SetSample(x, y, z, uint16(value))
+			}
+		}
+	}
+
+	return newImg
+}
+
+//funções que operam sobre os pixels
+
+func OperateOnTwoImages(
+	img1 *imgdata.Image,
+	img2 *imgdata.Image,
+	onPixel func(pixel1 uint16, pixel2 uint16) uint16,
+) *imgdata.Image {
+	newImg, _ := OperateOnTwoImagesContext(context.Background(), img1, img2, onPixel)
+
+	return newImg
+}
+
+//as versões com context param de trabalhar quando a requisição é cancelada, a verificação é feita a cada linha
+
+func OperateOnTwoImagesContext(
+	ctx context.Context,
+	img1 *imgdata.Image,
+	img2 *imgdata.Image,
+	onPixel func(pixel1 uint16, pixel2 uint16) uint16,
+) (*imgdata.Image, error) {
+	matchedImgs := matchFormats([]*imgdata.Image{img1, img2})
+	img1, img2 = matchedImgs[0], matchedImgs[1]
+
+	premultiplied := usesPremultipliedAlpha(img1)
+
+	if premultiplied {
+		img1 = premultiplyImage(img1)
+		img2 = premultiplyImage(img2)
+	}
+
+	maxWidth := getMaxNum(img1.Width, img2.Width)
+	maxHeight := getMaxNum(img1.Height, img2.Height)
+
+	channels := img1.Channels
+	alphaIndex := img1.AlphaIndex()
+
+	newImg := img1.NewBlank(maxWidth, maxHeight)
+
+	for y := 0; y < maxHeight; y++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		ReportProgress(ctx, y, maxHeight)
+
+		for x := 0; x < maxWidth; x++ {
+			for z := 0; z < channels; z++ {
+				var pixel1 uint16 = 0
+				var pixel2 uint16 = 0
+
+				if img1.InBounds(x, y) {
+					pixel1 = img1.Sample(x, y, z)
 				}
 
-				if x < len(*matrix2) && y < len((*matrix2)[0]) {
-					pixel2 = (*matrix2)[x][y][z]
+				if img2.InBounds(x, y) {
+					pixel2 = img2.Sample(x, y, z)
 				}
 
-				newY[z] = onPixel(pixel1, pixel2)
-			}
+				//com o alfa preservado a operação vale só para as cores, e a transparência das duas é sobreposta
+				if premultiplied && z == alphaIndex {
+					newImg.SetSample(x, y, z, overAlpha(pixel1, pixel2))
+					continue
+				}
 
-			newX = append(newX, newY)
+				newImg.SetSample(x, y, z, onPixel(pixel1, pixel2))
+			}
 		}
+	}
 
-		newMatrix = append(newMatrix, newX)
+	if premultiplied {
+		unpremultiplyImage(newImg)
 	}
 
-	return newMatrix
+	return newImg, nil
 }
 
-func multiplyPixel(factor float32, pixel uint8) uint8 {
-	newPixel := factor * float32(pixel)
+func OperateOnTwoMatrixes(
+	matrix1 *[][][3]uint8,
+	matrix2 *[][][3]uint8,
+	onPixel func(pixel1 uint8, pixel2 uint8) uint8,
+) [][][3]uint8 {
+	newImg := OperateOnTwoImages(imgdata.FromMatrix(matrix1), imgdata.FromMatrix(matrix2), toTwoSampleOperation(onPixel))
 
-	if newPixel > 255 {
-		newPixel = 255
-	}
+	return *newImg.ToMatrix()
+}
 
-	return uint8(newPixel)
+func multiplyPixel(factor float32, pixel uint16) uint16 {
+	newPixel := factor * float32(pixel)
+
+	return clampSample(float64(newPixel))
 }
 
-func MultiplyPixelCurry(factor float32) func(pixel uint8) uint8 {
-	return func(pixel uint8) uint8 {
+func MultiplyPixelCurry(factor float32) func(pixel uint16) uint16 {
+	return func(pixel uint16) uint16 {
 		return multiplyPixel(factor, pixel)
 	}
 }
 
+func OperateOnImage(
+	img *imgdata.Image,
+	onPixel func(pixel uint16) uint16,
+) {
+	operableChannels := img.OperableChannels()
+
+	if img.Depth == 8 {
+		var lookupTable [256]uint8
+
+		for i := 0; i < 256; i++ {
+			lookupTable[i] = uint8(onPixel(uint16(i)*257) / 257)
+		}
+
+		for y := 0; y < img.Height; y++ {
+			for x := 0; x < img.Width; x++ {
+				pixel := img.Pixel(x, y)
+
+				for z := 0; z < operableChannels; z++ {
+					pixel[z] = lookupTable[pixel[z]]
+				}
+			}
+		}
+
+		return
+	}
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			for z := 0; z < operableChannels; z++ {
+				img.SetSample(x, y, z, onPixel(img.Sample(x, y, z)))
+			}
+		}
+	}
+}
+
 func OperateOnMatrix(
 	matrix *[][][3]uint8,
 	onPixel func(pixel uint8) uint8,
 ) {
-	width := len(*matrix)
-	height := len((*matrix)[0])
+	img := imgdata.FromMatrix(matrix)
 
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			(*matrix)[x][y][0] = onPixel((*matrix)[x][y][0])
-			(*matrix)[x][y][1] = onPixel((*matrix)[x][y][1])
-			(*matrix)[x][y][2] = onPixel((*matrix)[x][y][2])
-		}
+	OperateOnImage(img, toSampleOperation(onPixel))
+
+	*matrix = *img.ToMatrix()
+}
+
+func grayValueOf(img *imgdata.Image, x int, y int) uint16 {
+	if img.IsGray() {
+		return img.Sample(x, y, 0)
 	}
+
+	sum := uint32(img.Sample(x, y, 0)) + uint32(img.Sample(x, y, 1)) + uint32(img.Sample(x, y, 2))
+
+	return uint16(sum / 3)
 }
 
-func ConvertMatrixToGrayscale(matrix *[][][3]uint8) {
-	width := len(*matrix)
-	height := len((*matrix)[0])
+func ConvertImageToGrayscale(img *imgdata.Image) *imgdata.Image {
+	if img.IsGray() {
+		return img
+	}
+
+	channels := 1
 
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			grayValueFloat32 := (float32((*matrix)[x][y][0]) + float32((*matrix)[x][y][1]) + float32((*matrix)[x][y][2])) / 3
+	if img.HasAlpha() {
+		channels = 2
+	}
+
+	newImg := imgdata.NewImageWithDepth(img.Width, img.Height, channels, img.Depth)
+	newImg.AlphaMode = img.AlphaMode
 
-			grayValue := uint8(grayValueFloat32)
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			newImg.SetSample(x, y, 0, grayValueOf(img, x, y))
 
-			(*matrix)[x][y][0] = grayValue
-			(*matrix)[x][y][1] = grayValue
-			(*matrix)[x][y][2] = grayValue
+			if img.HasAlpha() {
+				newImg.SetSample(x, y, 1, img.Sample(x, y, img.AlphaIndex()))
+			}
 		}
 	}
+
+	return newImg
 }
 
-func ConvertMatrixToBinary(matrix *[][][3]uint8) {
-	width := len(*matrix)
-	height := len((*matrix)[0])
+func ConvertMatrixToGrayscale(matrix *[][][3]uint8) {
+	img := ConvertImageToGrayscale(imgdata.FromMatrix(matrix))
 
-	pixelTotalSum := 0
+	*matrix = *img.ToMatrix()
+}
 
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			grayValueFloat32 := (float32((*matrix)[x][y][0]) + float32((*matrix)[x][y][1]) + float32((*matrix)[x][y][2])) / 3
+func ConvertImageToBinary(img *imgdata.Image) *imgdata.Image {
+	grayImg := ConvertImageToGrayscale(img)
 
-			grayValue := uint8(grayValueFloat32)
+	//imagens que já eram cinza voltam sem cópia
+	if grayImg == img {
+		grayImg = img.Copy()
+	}
 
-			pixelTotalSum += int(grayValue)
+	img = grayImg
 
-			(*matrix)[x][y][0] = grayValue
-			(*matrix)[x][y][1] = grayValue
-			(*matrix)[x][y][2] = grayValue
-		}
+	if img.Width == 0 || img.Height == 0 {
+		return img
 	}
 
-	threshold := uint8(pixelTotalSum / (width * height))
+	pixelTotalSum := 0
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			pixelTotalSum += int(img.Sample(x, y, 0))
+		}
+	}
 
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			pixelValue := (*matrix)[x][y][0]
+	threshold := uint16(pixelTotalSum / (img.Width * img.Height))
 
-			var newPixelValue uint8 = 0
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			var newPixelValue uint16 = 0
 
-			if pixelValue >= threshold {
-				newPixelValue = 255
+			if img.Sample(x, y, 0) >= threshold {
+				newPixelValue = imgdata.MaxSample
 			}
 
-			(*matrix)[x][y][0] = newPixelValue
-			(*matrix)[x][y][1] = newPixelValue
-			(*matrix)[x][y][2] = newPixelValue
+			img.SetSample(x, y, 0, newPixelValue)
 		}
 	}
+
+	return img
+}
+
+func ConvertMatrixToBinary(matrix *[][][3]uint8) {
+	img := ConvertImageToBinary(imgdata.FromMatrix(matrix))
+
+	*matrix = *img.ToMatrix()
+}
+
+func NOTImage(img *imgdata.Image) {
+	OperateOnImage(img, func(pixel uint16) uint16 {
+		return imgdata.MaxSample - pixel
+	})
 }
 
 func NOTMatrix(matrix *[][][3]uint8) {
-	width := len(*matrix)
-	height := len((*matrix)[0])
+	img := imgdata.FromMatrix(matrix)
 
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			(*matrix)[x][y][0] = 255 - (*matrix)[x][y][0]
-			(*matrix)[x][y][1] = 255 - (*matrix)[x][y][1]
-			(*matrix)[x][y][2] = 255 - (*matrix)[x][y][2]
-		}
-	}
+	NOTImage(img)
+
+	*matrix = *img.ToMatrix()
 }
 
-func EqualizeMatrixHistogram(matrix *[][][3]uint8) {
-	var hist [3][256]int
+const histogramBins = imgdata.MaxSample + 1
 
-	for i := 0; i < 3; i++ {
-		for j := 0; j < 256; j++ {
-			hist[i][j] = 0
-		}
+func EqualizeImageHistogram(img *imgdata.Image) {
+	EqualizeImageHistogramContext(context.Background(), img)
+}
+
+func EqualizeImageHistogramContext(ctx context.Context, img *imgdata.Image) error {
+	channels := img.OperableChannels()
+
+	hist := make([][]int, channels)
+
+	for z := 0; z < channels; z++ {
+		hist[z] = make([]int, histogramBins)
 	}
 
-	width := len(*matrix)
-	height := len((*matrix)[0])
+	for y := 0; y < img.Height; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			for z := 0; z < 3; z++ {
-				colorValue := (*matrix)[x][y][z]
-				hist[z][colorValue]++
+		//a contagem e a equalização são metade do trabalho cada
+		ReportProgress(ctx, y, 2*img.Height)
+
+		for x := 0; x < img.Width; x++ {
+			for z := 0; z < channels; z++ {
+				hist[z][img.Sample(x, y, z)]++
 			}
 		}
 	}
 
-	var histCFD [3][256]int
+	histCFD := make([][]int, channels)
 
-	for i := 0; i < 3; i++ {
+	for i := 0; i < channels; i++ {
+		histCFD[i] = make([]int, histogramBins)
 		histCFD[i][0] = hist[i][0]
-	}
 
-	for i := 0; i < 3; i++ {
-		for j := 1; j < 256; j++ {
+		for j := 1; j < histogramBins; j++ {
 			histCFD[i][j] = histCFD[i][j-1] + hist[i][j]
 		}
 	}
 
-	matrixSize := float64(width * height)
+	imgSize := float64(img.Width * img.Height)
 
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			for z := 0; z < 3; z++ {
-				colorValue := (*matrix)[x][y][z]
+	for y := 0; y < img.Height; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ReportProgress(ctx, img.Height+y, 2*img.Height)
 
-				histCFDValue := float64(histCFD[z][colorValue])
+		for x := 0; x < img.Width; x++ {
+			for z := 0; z < channels; z++ {
+				histCFDValue := float64(histCFD[z][img.Sample(x, y, z)])
 
 				histCFDMin := float64(histCFD[z][0])
 
-				result := math.Floor((histCFDValue - histCFDMin) / (matrixSize - histCFDMin) * 255)
+				result := math.Floor((histCFDValue - histCFDMin) / (imgSize - histCFDMin) * imgdata.MaxSample)
 
-				(*matrix)[x][y][z] = uint8(result)
+				img.SetSample(x, y, z, clampSample(result))
 			}
 		}
 	}
+
+	return nil
 }
 
-func GetColorPixelValues(matrix *[][][3]uint8) [3][]uint8 {
-	width := len(*matrix)
-	height := len((*matrix)[0])
+func EqualizeMatrixHistogram(matrix *[][][3]uint8) {
+	img := imgdata.FromMatrix(matrix)
+
+	EqualizeImageHistogram(img)
+
+	*matrix = *img.ToMatrix()
+}
 
+func GetImageColorPixelValues(img *imgdata.Image) [3][]uint8 {
 	var values [3][]uint8
 
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
+	for z := 0; z < 3; z++ {
+		values[z] = make([]uint8, 0, img.Width*img.Height)
+	}
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
 			for z := 0; z < 3; z++ {
-				colorIndexValue := (*matrix)[x][y][z]
-				values[z] = append(values[z], colorIndexValue)
+				values[z] = append(values[z], img.ColorAt(x, y, z))
 			}
 		}
 	}
@@ -283,171 +565,312 @@ func GetColorPixelValues(matrix *[][][3]uint8) [3][]uint8 {
 	return values
 }
 
-func ReplaceMatrixBlackForColor(colorIndex int, matrix *[][][3]uint8) {
-	width := len(*matrix)
-	height := len((*matrix)[0])
+func GetColorPixelValues(matrix *[][][3]uint8) [3][]uint8 {
+	return GetImageColorPixelValues(imgdata.FromMatrix(matrix))
+}
 
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			for z := 0; z < 3; z++ {
-				if (*matrix)[x][y][0] != 255 || (*matrix)[x][y][1] != 255 || (*matrix)[x][y][2] != 255 {
-					(*matrix)[x][y][0] = 0
-					(*matrix)[x][y][1] = 0
-					(*matrix)[x][y][2] = 0
+func ReplaceImageBlackForColor(colorIndex int, img *imgdata.Image) *imgdata.Image {
+	img = img.WithColor()
 
-					(*matrix)[x][y][colorIndex] = 255
-				}
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			if img.At(x, y, 0) != 255 || img.At(x, y, 1) != 255 || img.At(x, y, 2) != 255 {
+				img.Set(x, y, 0, 0)
+				img.Set(x, y, 1, 0)
+				img.Set(x, y, 2, 0)
+
+				img.Set(x, y, colorIndex, 255)
 			}
 		}
 	}
+
+	return img
 }
 
-func makeVerticalLine(width int, RGB [3]uint8, amountOfPixels int) *[][][3]uint8 {
-	var verticalLine [][][3]uint8
+func ReplaceMatrixBlackForColor(colorIndex int, matrix *[][][3]uint8) {
+	img := ReplaceImageBlackForColor(colorIndex, imgdata.FromMatrix(matrix))
 
-	for x := 0; x < amountOfPixels; x++ {
-		newX := [][3]uint8{}
+	*matrix = *img.ToMatrix()
+}
 
-		for y := 0; y < width; y++ {
-			newX = append(newX, RGB)
-		}
+func makeSeparatorColor(img *imgdata.Image) []uint8 {
+	color := make([]uint8, img.Channels*img.BytesPerSample())
 
-		verticalLine = append(verticalLine, newX)
+	for i := range color {
+		color[i] = 255
 	}
 
-	return &verticalLine
+	return color
 }
 
-func makeHorizontalLine(width int, RGB [3]uint8, amountOfPixels int) *[][][3]uint8 {
-	var horizontalLine [][][3]uint8
+func drawImage(dst *imgdata.Image, src *imgdata.Image, offsetX int, offsetY int) {
+	rowSize := src.Width * src.Channels * src.BytesPerSample()
 
-	for x := 0; x < width; x++ {
-		newX := [][3]uint8{}
+	for y := 0; y < src.Height; y++ {
+		dstStart := dst.Offset(offsetX, offsetY+y)
+		srcStart := src.Offset(0, y)
 
-		for y := 0; y < amountOfPixels; y++ {
-			newX = append(newX, RGB)
-		}
+		copy(dst.Pix[dstStart:dstStart+rowSize], src.Pix[srcStart:srcStart+rowSize])
+	}
+}
+
+func CombineImagesHorizontally(imgs []*imgdata.Image, separatorWidth int) *imgdata.Image {
+	imgs = matchFormats(imgs)
 
-		horizontalLine = append(horizontalLine, newX)
+	width := 0
+	height := 0
+
+	for _, img := range imgs {
+		width += img.Width + 2*separatorWidth
+		height = getMaxNum(height, img.Height)
 	}
 
-	return &horizontalLine
-}
+	newImg := imgs[0].NewBlank(width, height)
+	newImg.Fill(makeSeparatorColor(newImg))
 
-func CombineMatrixesHorizontally(matrixes []*[][][3]uint8, separatorWidth int) *[][][3]uint8 {
-	var newMatrix [][][3]uint8
+	x := 0
+
+	for _, img := range imgs {
+		x += separatorWidth
 
-	for i := 0; i < len(matrixes); i++ {
-		verticalLine := makeVerticalLine(len((*matrixes[i])[0]), [3]uint8{255, 255, 255}, separatorWidth)
+		drawImage(newImg, img, x, 0)
 
-		newMatrix = append(newMatrix, *verticalLine...)
+		x += img.Width + separatorWidth
+	}
+
+	return newImg
+}
 
-		newMatrix = append(newMatrix, *matrixes[i]...)
+func toImages(matrixes []*[][][3]uint8) []*imgdata.Image {
+	imgs := make([]*imgdata.Image, len(matrixes))
 
-		newMatrix = append(newMatrix, *verticalLine...)
+	for i, matrix := range matrixes {
+		imgs[i] = imgdata.FromMatrix(matrix)
 	}
 
-	return &newMatrix
+	return imgs
 }
 
-func CombineMatrixesVertically(matrixes []*[][][3]uint8, separatorWidth int) *[][][3]uint8 {
-	var newMatrix [][][3]uint8
+func CombineMatrixesHorizontally(matrixes []*[][][3]uint8, separatorWidth int) *[][][3]uint8 {
+	return CombineImagesHorizontally(toImages(matrixes), separatorWidth).ToMatrix()
+}
 
-	width := len(*matrixes[0])
+func CombineImagesVertically(imgs []*imgdata.Image, separatorWidth int) *imgdata.Image {
+	imgs = matchFormats(imgs)
 
-	firstHorizontalLine := makeHorizontalLine(width, [3]uint8{255, 255, 255}, 0)
+	width := imgs[0].Width
+	height := 0
 
-	newMatrix = append(newMatrix, *firstHorizontalLine...)
+	for _, img := range imgs {
+		height += img.Height + 2*separatorWidth
+	}
 
-	for i := 0; i < len(matrixes); i++ {
-		horizontalLine := makeHorizontalLine(len(*matrixes[i]), [3]uint8{255, 255, 255}, separatorWidth)
+	newImg := imgs[0].NewBlank(width, height)
+	newImg.Fill(makeSeparatorColor(newImg))
 
-		for x := 0; x < width; x++ {
-			newMatrix[x] = append(newMatrix[x], (*horizontalLine)[x]...)
+	y := 0
 
-			newMatrix[x] = append(newMatrix[x], (*matrixes[i])[x]...)
+	for _, img := range imgs {
+		y += separatorWidth
 
-			newMatrix[x] = append(newMatrix[x], (*horizontalLine)[x]...)
+		cropped := img
+
+		if img.Width > width {
+			cropped = CropImage(img, 0, 0, width, img.Height)
 		}
+
+		drawImage(newImg, cropped, 0, y)
+
+		y += img.Height + separatorWidth
 	}
 
-	return &newMatrix
+	return newImg
 }
 
-func ResizeNearestNeighbor(matrix *[][][3]uint8, newWidth uint64, newHeight uint64) *[][][3]uint8 {
-	width := len(*matrix)
-	height := len((*matrix)[0])
+func CombineMatrixesVertically(matrixes []*[][][3]uint8, separatorWidth int) *[][][3]uint8 {
+	return CombineImagesVertically(toImages(matrixes), separatorWidth).ToMatrix()
+}
+
+func CropImage(img *imgdata.Image, x0 int, y0 int, width int, height int) *imgdata.Image {
+	newImg := img.NewBlank(width, height)
+
+	rowSize := width * img.Channels * img.BytesPerSample()
+
+	for y := 0; y < height; y++ {
+		srcStart := img.Offset(x0, y0+y)
+		dstStart := newImg.Offset(0, y)
+
+		copy(newImg.Pix[dstStart:dstStart+rowSize], img.Pix[srcStart:srcStart+rowSize])
+	}
+
+	return newImg
+}
+
+func ResizeImageNearestNeighbor(img *imgdata.Image, newWidth uint64, newHeight uint64) *imgdata.Image {
+	newImg, _ := ResizeImageNearestNeighborContext(context.Background(), img, newWidth, newHeight)
+
+	return newImg
+}
+
+func ResizeImageNearestNeighborContext(ctx context.Context, img *imgdata.Image, newWidth uint64, newHeight uint64) (*imgdata.Image, error) {
+	width := img.Width
+	height := img.Height
 
 	scaleX := 1 / (float64(newWidth) / float64(width))
 	scaleY := 1 / (float64(newHeight) / float64(height))
 
-	var newMatrix [][][3]uint8
+	newImg := img.NewBlank(int(newWidth), int(newHeight))
 
-	for x := 0; x < int(newWidth); x++ {
-		newX := [][3]uint8{}
+	for y := 0; y < int(newHeight); y++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-		oldX := int(math.Min(float64(x)*scaleX, float64(width-1)))
+		ReportProgress(ctx, y, int(newHeight))
 
-		for y := 0; y < int(newHeight); y++ {
-			oldY := int(math.Min(float64(y)*scaleY, float64(height-1)))
+		oldY := int(math.Min(float64(y)*scaleY, float64(height-1)))
 
-			newY := (*matrix)[oldX][oldY]
+		for x := 0; x < int(newWidth); x++ {
+			oldX := int(math.Min(float64(x)*scaleX, float64(width-1)))
 
-			newX = append(newX, newY)
+			newImg.SetPixel(x, y, img.Pixel(oldX, oldY))
 		}
-
-		newMatrix = append(newMatrix, newX)
 	}
 
-	return &newMatrix
+	return newImg, nil
 }
 
-func CopyMatrix(matrix *[][][3]uint8) *[][][3]uint8 {
-	width := len(*matrix)
-	height := len((*matrix)[0])
+func ResizeNearestNeighbor(matrix *[][][3]uint8, newWidth uint64, newHeight uint64) *[][][3]uint8 {
+	return ResizeImageNearestNeighbor(imgdata.FromMatrix(matrix), newWidth, newHeight).ToMatrix()
+}
 
-	var newMatrix [][][3]uint8
+//funções de rotação e espelhamento
 
-	for x := 0; x < width; x++ {
-		newX := [][3]uint8{}
+func remapImage(img *imgdata.Image, newWidth int, newHeight int, sourceOf func(x int, y int) (int, int)) *imgdata.Image {
+	newImg := img.NewBlank(newWidth, newHeight)
 
-		for y := 0; y < height; y++ {
-			newY := (*matrix)[x][y]
+	for y := 0; y < newHeight; y++ {
+		for x := 0; x < newWidth; x++ {
+			sourceX, sourceY := sourceOf(x, y)
 
-			newX = append(newX, newY)
+			newImg.SetPixel(x, y, img.Pixel(sourceX, sourceY))
 		}
+	}
+
+	return newImg
+}
+
+func FlipImageHorizontally(img *imgdata.Image) *imgdata.Image {
+	return remapImage(img, img.Width, img.Height, func(x int, y int) (int, int) {
+		return img.Width - 1 - x, y
+	})
+}
+
+func FlipImageVertically(img *imgdata.Image) *imgdata.Image {
+	return remapImage(img, img.Width, img.Height, func(x int, y int) (int, int) {
+		return x, img.Height - 1 - y
+	})
+}
+
+func RotateImage90(img *imgdata.Image) *imgdata.Image {
+	return remapImage(img, img.Height, img.Width, func(x int, y int) (int, int) {
+		return y, img.Height - 1 - x
+	})
+}
 
-		newMatrix = append(newMatrix, newX)
+func RotateImage180(img *imgdata.Image) *imgdata.Image {
+	return remapImage(img, img.Width, img.Height, func(x int, y int) (int, int) {
+		return img.Width - 1 - x, img.Height - 1 - y
+	})
+}
+
+func RotateImage270(img *imgdata.Image) *imgdata.Image {
+	return remapImage(img, img.Height, img.Width, func(x int, y int) (int, int) {
+		return img.Width - 1 - y, x
+	})
+}
+
+func TransposeImage(img *imgdata.Image) *imgdata.Image {
+	return remapImage(img, img.Height, img.Width, func(x int, y int) (int, int) {
+		return y, x
+	})
+}
+
+func TransverseImage(img *imgdata.Image) *imgdata.Image {
+	return remapImage(img, img.Height, img.Width, func(x int, y int) (int, int) {
+		return img.Width - 1 - y, img.Height - 1 - x
+	})
+}
+
+//aplica a orientação EXIF (1 a 8) para que a imagem fique na posição correta
+
+func ApplyOrientation(img *imgdata.Image, orientation int) *imgdata.Image {
+	switch orientation {
+	case 2:
+		return FlipImageHorizontally(img)
+	case 3:
+		return RotateImage180(img)
+	case 4:
+		return FlipImageVertically(img)
+	case 5:
+		return TransposeImage(img)
+	case 6:
+		return RotateImage90(img)
+	case 7:
+		return TransverseImage(img)
+	case 8:
+		return RotateImage270(img)
 	}
 
-	return &newMatrix
+	return img
+}
+
+func CopyMatrix(matrix *[][][3]uint8) *[][][3]uint8 {
+	return imgdata.FromMatrix(matrix).ToMatrix()
 }
 
 //função generica para qualquer filtro
 
-func ApplyFilter(matrix *[][][3]uint8, mask [][]float64, operation func(pixels []float64) uint8) *[][][3]uint8 {
-	width := len(*matrix)
-	height := len((*matrix)[0])
+func ApplyFilterToImage(img *imgdata.Image, mask [][]float64, operation func(pixels []float64) uint16) *imgdata.Image {
+	newImg, _ := ApplyFilterToImageContext(context.Background(), img, mask, operation)
+
+	return newImg
+}
+
+func ApplyFilterToImageContext(ctx context.Context, img *imgdata.Image, mask [][]float64, operation func(pixels []float64) uint16) (*imgdata.Image, error) {
+	width := img.Width
+	height := img.Height
+	channels := img.Channels
 
 	maskSize := len(mask)
 
 	maskCenter := maskSize / 2
 
-	var newMatrix [][][3]uint8
+	newImg := img.Copy()
 
-	for x := 0; x < width; x++ {
-		newX := [][3]uint8{}
+	premultiplied := usesPremultipliedAlpha(img)
 
-		for y := 0; y < height; y++ {
+	if premultiplied {
+		img = premultiplyImage(img)
+	}
 
-			if x == 0 || y == 0 || x == width-1 || y == height-1 {
-				newX = append(newX, (*matrix)[x][y])
-				continue
-			}
+	channelPixels := make([][]float64, channels)
 
-			var redPixels []float64
-			var greenPixels []float64
-			var bluePixels []float64
+	for z := 0; z < channels; z++ {
+		channelPixels[z] = make([]float64, 0, maskSize*maskSize)
+	}
+
+	for y := 1; y < height-1; y++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		ReportProgress(ctx, y-1, height-2)
+
+		for x := 1; x < width-1; x++ {
+			for z := 0; z < channels; z++ {
+				channelPixels[z] = channelPixels[z][:0]
+			}
 
 			for maskX := 0; maskX < maskSize; maskX++ {
 				neighborX := x + maskX - maskCenter
@@ -455,35 +878,35 @@ func ApplyFilter(matrix *[][][3]uint8, mask [][]float64, operation func(pixels [
 				for maskY := 0; maskY < maskSize; maskY++ {
 					neighborY := y + maskY - maskCenter
 
-					neighbor := [3]uint8{0, 0, 0}
+					inBounds := img.InBounds(neighborX, neighborY)
 
-					if neighborX >= 0 && neighborX < width && neighborY >= 0 && neighborY < height {
-						neighbor = (*matrix)[neighborX][neighborY]
-					}
+					for z := 0; z < channels; z++ {
+						var neighbor uint16 = 0
 
-					redPixel := float64(neighbor[0]) * mask[maskX][maskY]
-					greenPixel := float64(neighbor[1]) * mask[maskX][maskY]
-					bluePixel := float64(neighbor[2]) * mask[maskX][maskY]
+						if inBounds {
+							neighbor = img.Sample(neighborX, neighborY, z)
+						}
 
-					redPixels = append(redPixels, redPixel)
-					greenPixels = append(greenPixels, greenPixel)
-					bluePixels = append(bluePixels, bluePixel)
+						channelPixels[z] = append(channelPixels[z], float64(neighbor)*mask[maskX][maskY])
+					}
 				}
 			}
 
-			redResult := operation(redPixels)
-			greenResult := operation(greenPixels)
-			blueResult := operation(bluePixels)
-
-			result := [3]uint8{redResult, greenResult, blueResult}
+			for z := 0; z < channels; z++ {
+				newImg.SetSample(x, y, z, operation(channelPixels[z]))
+			}
 
-			newX = append(newX, result)
+			if premultiplied {
+				unpremultiplyPixel(newImg, x, y)
+			}
 		}
-
-		newMatrix = append(newMatrix, newX)
 	}
 
-	return &newMatrix
+	return newImg, nil
+}
+
+func ApplyFilter(matrix *[][][3]uint8, mask [][]float64, operation func(pixels []float64) uint8) *[][][3]uint8 {
+	return ApplyFilterToImage(imgdata.FromMatrix(matrix), mask, toSampleFilterOperation(operation)).ToMatrix()
 }
 
 //funções para criar mascaras de filtros
@@ -546,8 +969,6 @@ func MakeGaussMask(size int, sigma float64) [][]float64 {
 		mask = append(mask, maskRow)
 	}
 
-	fmt.Println(Matrix2String(&mask))
-
 	for x := range mask {
 		for y := range mask[x] {
 			mask[x][y] /= sum
@@ -559,7 +980,7 @@ func MakeGaussMask(size int, sigma float64) [][]float64 {
 
 //funções para calcular valor do pixel alvo nos filtros
 
-func PixelsMax(pixels []float64) uint8 {
+func PixelsMax(pixels []float64) uint16 {
 	var maxPixel float64 = math.Inf(-1)
 
 	for i := 0; i < len(pixels); i++ {
@@ -568,10 +989,10 @@ func PixelsMax(pixels []float64) uint8 {
 		}
 	}
 
-	return uint8(maxPixel)
+	return clampSample(maxPixel)
 }
 
-func PixelsMin(pixels []float64) uint8 {
+func PixelsMin(pixels []float64) uint16 {
 	minPixel := math.Inf(1)
 
 	for i := 0; i < len(pixels); i++ {
@@ -580,10 +1001,10 @@ func PixelsMin(pixels []float64) uint8 {
 		}
 	}
 
-	return uint8(minPixel)
+	return clampSample(minPixel)
 }
 
-func PixelsAvg(pixels []float64) uint8 {
+func PixelsAvg(pixels []float64) uint16 {
 	var sum float64 = 0
 
 	arrSize := len(pixels)
@@ -592,43 +1013,43 @@ func PixelsAvg(pixels []float64) uint8 {
 		sum += pixels[i]
 	}
 
-	avg := uint8(sum / float64(arrSize))
+	avg := clampSample(sum / float64(arrSize))
 
 	return avg
 }
 
-func PixelsMean(pixels []float64) uint8 {
+func PixelsMean(pixels []float64) uint16 {
 	arrCenter := len(pixels) / 2
 
 	sort.Float64s(pixels)
 
-	return uint8(pixels[arrCenter])
+	return clampSample(pixels[arrCenter])
 }
 
-func PixelsSum(pixels []float64) uint8 {
+func PixelsSum(pixels []float64) uint16 {
 	sum := 0.0
 	for _, pixel := range pixels {
 		sum += pixel
 	}
-	return uint8(sum)
+	return clampSample(sum)
 }
 
-func GetPixelByIndexInSortedArr(pixels []float64, index int) uint8 {
+func GetPixelByIndexInSortedArr(pixels []float64, index int) uint16 {
 	sort.Float64s(pixels)
 
-	return uint8(pixels[index])
+	return clampSample(pixels[index])
 }
 
-func GetPixelByIndexInSortedArrCurry(index int) func(pixels []float64) uint8 {
-	return func(pixels []float64) uint8 {
+func GetPixelByIndexInSortedArrCurry(index int) func(pixels []float64) uint16 {
+	return func(pixels []float64) uint16 {
 		return GetPixelByIndexInSortedArr(pixels, index)
 	}
 }
 
-func GetPixelBoundedByNeighborsRange(pixels []float64) uint8 {
+func GetPixelBoundedByNeighborsRange(pixels []float64) uint16 {
 	arrCenter := len(pixels) / 2
 
-	centerPixel := uint8(pixels[arrCenter])
+	centerPixel := clampSample(pixels[arrCenter])
 
 	nonCenterPixels := []float64{}
 	for i, pixel := range pixels {
@@ -643,7 +1064,7 @@ func GetPixelBoundedByNeighborsRange(pixels []float64) uint8 {
 
 	min := PixelsMin(nonCenterPixels)
 
-	var result uint8
+	var result uint16
 
 	if max < centerPixel {
 		result = max
@@ -0,0 +1,35 @@
+package imgprocessing
+
+import (
+	"context"
+)
+
+//parte que avisa quanto de uma operação já foi feito, o aviso vai junto com o context
+
+type ProgressReporter func(done int, total int)
+
+type progressKey struct{}
+
+func WithProgress(ctx context.Context, reporter ProgressReporter) context.Context {
+	return context.WithValue(ctx, progressKey{}, reporter)
+}
+
+func ReportProgress(ctx context.Context, done int, total int) {
+	reporter, exists := ctx.Value(progressKey{}).(ProgressReporter)
+
+	if exists && total > 0 {
+		reporter(done, total)
+	}
+}
+
+//para operações divididas em partes, como quadros ou passos, cada parte avisa só sobre o seu pedaço do total
+
+func ScaleProgress(ctx context.Context, part int, parts int) context.Context {
+	if _, exists := ctx.Value(progressKey{}).(ProgressReporter); !exists {
+		return ctx
+	}
+
+	return WithProgress(ctx, func(done int, total int) {
+		ReportProgress(ctx, part*total+done, parts*total)
+	})
+}
@@ -0,0 +1,344 @@
+package imgprocessing
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+
+	"img-ops/imgdata"
+	"img-ops/imgerrors"
+)
+
+//parte que descreve as operações disponíveis, usada para gerar rotas e validar parâmetros
+
+type ParamType string
+
+const (
+	ParamInt   ParamType = "int"
+	ParamFloat ParamType = "float"
+)
+
+type Param struct {
+	Name    string    `json:"name"`
+	Type    ParamType `json:"type"`
+	Min     *float64  `json:"min,omitempty"`
+	Max     *float64  `json:"max,omitempty"`
+	Default *float64  `json:"default,omitempty"`
+}
+
+func (param Param) Required() bool {
+	return param.Default == nil
+}
+
+type Params map[string]float64
+
+func (params Params) Int(name string) int {
+	return int(params[name])
+}
+
+func (params Params) Float(name string) float64 {
+	return params[name]
+}
+
+type Operation struct {
+	Name   string  `json:"name"`
+	Arity  int     `json:"arity"`
+	Params []Param `json:"params"`
+
+	//validações que dependem de mais de um parâmetro
+	Validate func(params Params) error `json:"-"`
+
+	//img2 é nil quando a operação usa só uma imagem, e as imagens recebidas nunca são alteradas, já que podem ser quadros repetidos ou o histórico de uma sessão
+	Apply func(ctx context.Context, img1 *imgdata.Image, img2 *imgdata.Image, params Params) (*imgdata.Image, error) `json:"-"`
+}
+
+func (op *Operation) checkParam(param Param, value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return imgerrors.InvalidParam(param.Name, param.Name+" must be a finite number")
+	}
+
+	if param.Type == ParamInt && value != math.Trunc(value) {
+		return imgerrors.InvalidParam(param.Name, param.Name+" must be an integer")
+	}
+
+	if param.Min != nil && value < *param.Min {
+		return imgerrors.InvalidParam(param.Name, param.Name+" must be at least "+strconv.FormatFloat(*param.Min, 'g', -1, 64))
+	}
+
+	if param.Max != nil && value > *param.Max {
+		return imgerrors.InvalidParam(param.Name, param.Name+" must be at most "+strconv.FormatFloat(*param.Max, 'g', -1, 64))
+	}
+
+	return nil
+}
+
+func (op *Operation) ResolveParams(values map[string]float64) (Params, error) {
+	params := Params{}
+
+	for name := range values {
+		if !op.hasParam(name) {
+			return nil, imgerrors.InvalidParam(name, op.Name+" has no parameter "+name)
+		}
+	}
+
+	for _, param := range op.Params {
+		value, exists := values[param.Name]
+
+		if !exists {
+			if param.Required() {
+				return nil, imgerrors.InvalidParam(param.Name, "missing parameter "+param.Name)
+			}
+
+			value = *param.Default
+		}
+
+		err := op.checkParam(param, value)
+		if err != nil {
+			return nil, err
+		}
+
+		params[param.Name] = value
+	}
+
+	if op.Validate != nil {
+		err := op.Validate(params)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return params, nil
+}
+
+//valores vindos da URL chegam como texto
+
+func (op *Operation) ParseParams(values map[string]string) (Params, error) {
+	parsed := map[string]float64{}
+
+	for name, valueStr := range values {
+		value, err := strconv.ParseFloat(valueStr, 64)
+		if err != nil {
+			return nil, imgerrors.InvalidParam(name, name+" must be a number")
+		}
+
+		parsed[name] = value
+	}
+
+	return op.ResolveParams(parsed)
+}
+
+func (op *Operation) hasParam(name string) bool {
+	for _, param := range op.Params {
+		if param.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+var registry = map[string]*Operation{}
+
+func RegisterOperation(op Operation) {
+	if _, exists := registry[op.Name]; exists {
+		panic("imgprocessing: operation " + op.Name + " registered twice")
+	}
+
+	if op.Arity != 1 && op.Arity != 2 {
+		panic("imgprocessing: operation " + op.Name + " must use one or two images")
+	}
+
+	registry[op.Name] = &op
+}
+
+func LookupOperation(name string) (*Operation, bool) {
+	op, exists := registry[name]
+
+	return op, exists
+}
+
+func Operations() []*Operation {
+	ops := make([]*Operation, 0, len(registry))
+
+	for _, op := range registry {
+		ops = append(ops, op)
+	}
+
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].Name < ops[j].Name
+	})
+
+	return ops
+}
+
+//funções para declarar operações
+
+func Bound(value float64) *float64 {
+	return &value
+}
+
+func TwoImagePixelOperation(pixelOperation func(pixel1 uint16, pixel2 uint16) uint16) func(ctx context.Context, img1 *imgdata.Image, img2 *imgdata.Image, params Params) (*imgdata.Image, error) {
+	return func(ctx context.Context, img1 *imgdata.Image, img2 *imgdata.Image, params Params) (*imgdata.Image, error) {
+		return OperateOnTwoImagesContext(ctx, img1, img2, pixelOperation)
+	}
+}
+
+func maskOfOnesFilterOperation(operation func(pixels []float64) uint16) func(ctx context.Context, img1 *imgdata.Image, img2 *imgdata.Image, params Params) (*imgdata.Image, error) {
+	return func(ctx context.Context, img *imgdata.Image, _ *imgdata.Image, params Params) (*imgdata.Image, error) {
+		return ApplyFilterToImageContext(ctx, img, MakeMaskOfOnes(params.Int("maskSize")), operation)
+	}
+}
+
+var factorParam = Param{Name: "factor", Type: ParamFloat, Min: Bound(0)}
+
+var maskSizeParam = Param{Name: "maskSize", Type: ParamInt, Min: Bound(1), Max: Bound(101)}
+
+func init() {
+	//operações com duas imagens
+
+	RegisterOperation(Operation{Name: "add", Arity: 2, Apply: TwoImagePixelOperation(AddPixels)})
+
+	RegisterOperation(Operation{Name: "subtract", Arity: 2, Apply: TwoImagePixelOperation(SubtractPixels)})
+
+	RegisterOperation(Operation{
+		Name:   "blend",
+		Arity:  2,
+		Params: []Param{{Name: "factor", Type: ParamFloat, Min: Bound(0), Max: Bound(1)}},
+		Apply: func(ctx context.Context, img1 *imgdata.Image, img2 *imgdata.Image, params Params) (*imgdata.Image, error) {
+			return OperateOnTwoImagesContext(ctx, img1, img2, BlendPixelsCurry(float32(params.Float("factor"))))
+		},
+	})
+
+	RegisterOperation(Operation{Name: "avg", Arity: 2, Apply: TwoImagePixelOperation(AvgPixels)})
+
+	RegisterOperation(Operation{Name: "and", Arity: 2, Apply: TwoImagePixelOperation(ANDPixels)})
+
+	RegisterOperation(Operation{Name: "or", Arity: 2, Apply: TwoImagePixelOperation(ORPixels)})
+
+	RegisterOperation(Operation{Name: "xor", Arity: 2, Apply: TwoImagePixelOperation(XORPixels)})
+
+	//operações com uma imagem
+
+	RegisterOperation(Operation{
+		Name:   "multiply",
+		Arity:  1,
+		Params: []Param{factorParam},
+		Apply: func(ctx context.Context, img *imgdata.Image, _ *imgdata.Image, params Params) (*imgdata.Image, error) {
+			img = img.Copy()
+
+			OperateOnImage(img, MultiplyPixelCurry(float32(params.Float("factor"))))
+
+			return img, nil
+		},
+	})
+
+	RegisterOperation(Operation{
+		Name:   "divide",
+		Arity:  1,
+		Params: []Param{factorParam},
+		Validate: func(params Params) error {
+			if params.Float("factor") == 0 {
+				return imgerrors.InvalidParam("factor", "factor must not be 0")
+			}
+
+			return nil
+		},
+		Apply: func(ctx context.Context, img *imgdata.Image, _ *imgdata.Image, params Params) (*imgdata.Image, error) {
+			img = img.Copy()
+
+			OperateOnImage(img, MultiplyPixelCurry(float32(1/params.Float("factor"))))
+
+			return img, nil
+		},
+	})
+
+	RegisterOperation(Operation{
+		Name:  "not",
+		Arity: 1,
+		Apply: func(ctx context.Context, img *imgdata.Image, _ *imgdata.Image, params Params) (*imgdata.Image, error) {
+			img = img.Copy()
+
+			NOTImage(img)
+
+			return img, nil
+		},
+	})
+
+	RegisterOperation(Operation{
+		Name:  "grayscale",
+		Arity: 1,
+		Apply: func(ctx context.Context, img *imgdata.Image, _ *imgdata.Image, params Params) (*imgdata.Image, error) {
+			return ConvertImageToGrayscale(img), nil
+		},
+	})
+
+	RegisterOperation(Operation{
+		Name:  "binary",
+		Arity: 1,
+		Apply: func(ctx context.Context, img *imgdata.Image, _ *imgdata.Image, params Params) (*imgdata.Image, error) {
+			return ConvertImageToBinary(img), nil
+		},
+	})
+
+	RegisterOperation(Operation{
+		Name:  "equalize-histogram",
+		Arity: 1,
+		Apply: func(ctx context.Context, img *imgdata.Image, _ *imgdata.Image, params Params) (*imgdata.Image, error) {
+			img = img.Copy()
+
+			err := EqualizeImageHistogramContext(ctx, img)
+
+			return img, err
+		},
+	})
+
+	//filtros
+
+	RegisterOperation(Operation{Name: "filter/max", Arity: 1, Params: []Param{maskSizeParam}, Apply: maskOfOnesFilterOperation(PixelsMax)})
+
+	RegisterOperation(Operation{Name: "filter/min", Arity: 1, Params: []Param{maskSizeParam}, Apply: maskOfOnesFilterOperation(PixelsMin)})
+
+	RegisterOperation(Operation{Name: "filter/avg", Arity: 1, Params: []Param{maskSizeParam}, Apply: maskOfOnesFilterOperation(PixelsAvg)})
+
+	RegisterOperation(Operation{Name: "filter/mean", Arity: 1, Params: []Param{maskSizeParam}, Apply: maskOfOnesFilterOperation(PixelsMean)})
+
+	RegisterOperation(Operation{
+		Name:   "filter/conservative-smoothing",
+		Arity:  1,
+		Params: []Param{maskSizeParam},
+		Apply:  maskOfOnesFilterOperation(GetPixelBoundedByNeighborsRange),
+	})
+
+	RegisterOperation(Operation{
+		Name:   "filter/order",
+		Arity:  1,
+		Params: []Param{maskSizeParam, {Name: "index", Type: ParamInt, Min: Bound(0)}},
+		Validate: func(params Params) error {
+			maskSize := params.Int("maskSize")
+			maxIndex := maskSize*maskSize - 1
+
+			if params.Int("index") > maxIndex {
+				return imgerrors.InvalidParam("index", "index must be between 0 and "+strconv.Itoa(maxIndex))
+			}
+
+			return nil
+		},
+		Apply: func(ctx context.Context, img *imgdata.Image, _ *imgdata.Image, params Params) (*imgdata.Image, error) {
+			mask := MakeMaskOfOnes(params.Int("maskSize"))
+
+			return ApplyFilterToImageContext(ctx, img, mask, GetPixelByIndexInSortedArrCurry(params.Int("index")))
+		},
+	})
+
+	RegisterOperation(Operation{
+		Name:   "filter/gaussian",
+		Arity:  1,
+		Params: []Param{maskSizeParam, {Name: "sigma", Type: ParamFloat, Min: Bound(0.01)}},
+		Apply: func(ctx context.Context, img *imgdata.Image, _ *imgdata.Image, params Params) (*imgdata.Image, error) {
+			gaussMask := MakeGaussMask(params.Int("maskSize"), params.Float("sigma"))
+
+			return ApplyFilterToImageContext(ctx, img, gaussMask, PixelsSum)
+		},
+	})
+}
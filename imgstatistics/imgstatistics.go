@@ -2,12 +2,15 @@ package imgstatistics
 
 import (
 	"bytes"
+	"context"
 
 	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/plotter"
 	"gonum.org/v1/plot/vg"
 
 	"img-ops/imgconversion"
+	"img-ops/imgdata"
+	"img-ops/imgerrors"
 	"img-ops/imgprocessing"
 )
 
@@ -55,58 +58,110 @@ func makePixelHist(colorName string, pixelValues []uint8) (*bytes.Buffer, error)
 	return buf, nil
 }
 
-func GetMatrixHistRGB(matrix *[][][3]uint8) (*[][][3]uint8, error) {
-	var matrixes []*[][][3]uint8
+func GetImageHistRGB(img *imgdata.Image) (*imgdata.Image, error) {
+	var imgs []*imgdata.Image
 
 	colorNames := [3]string{"red", "green", "blue"}
 
-	colorPixelValues := imgprocessing.GetColorPixelValues(matrix)
+	colorPixelValues := imgprocessing.GetImageColorPixelValues(img)
 
 	for i := 0; i < 3; i++ {
+		//o gráfico é gerado aqui, então qualquer falha é interna
 		histBuf, err := makePixelHist(colorNames[i], colorPixelValues[i])
 		if err != nil {
-			return nil, err
+			return nil, imgerrors.Wrap(imgerrors.CodeInternal, err)
 		}
 
-		histMatrix, err := imgconversion.LoadImg(histBuf)
+		histImg, err := imgconversion.LoadImage(histBuf)
 		if err != nil {
-			return nil, err
+			return nil, imgerrors.Wrap(imgerrors.CodeInternal, err)
 		}
 
-		imgprocessing.ReplaceMatrixBlackForColor(i, histMatrix)
+		histImg = imgprocessing.ReplaceImageBlackForColor(i, histImg)
 
-		matrixes = append(matrixes, histMatrix)
+		imgs = append(imgs, histImg)
 	}
 
-	newMatrix := imgprocessing.CombineMatrixesHorizontally(matrixes, 5)
+	newImg := imgprocessing.CombineImagesHorizontally(imgs, 5)
 
-	return newMatrix, nil
+	return newImg, nil
 }
 
-func CompareHistograms(matrix1 *[][][3]uint8, matrix2 *[][][3]uint8) (*[][][3]uint8, error) {
-	histMatrix1, err := GetMatrixHistRGB(matrix1)
+func GetMatrixHistRGB(matrix *[][][3]uint8) (*[][][3]uint8, error) {
+	histImg, err := GetImageHistRGB(imgdata.FromMatrix(matrix))
+	if err != nil {
+		return nil, err
+	}
+
+	return histImg.ToMatrix(), nil
+}
+
+func CompareImageHistograms(img1 *imgdata.Image, img2 *imgdata.Image) (*imgdata.Image, error) {
+	histImg1, err := GetImageHistRGB(img1)
 	if err != nil {
 		return nil, err
 	}
 
-	histMatrix2, err := GetMatrixHistRGB(matrix2)
+	histImg2, err := GetImageHistRGB(img2)
 	if err != nil {
 		return nil, err
 	}
 
-	matrix1Resized := imgprocessing.ResizeNearestNeighbor(matrix1, 500, 500)
+	img1Resized := imgprocessing.ResizeImageNearestNeighbor(img1, 500, 500)
 
-	histMatrix1Resized := imgprocessing.ResizeNearestNeighbor(histMatrix1, 1500, 500)
+	histImg1Resized := imgprocessing.ResizeImageNearestNeighbor(histImg1, 1500, 500)
 
-	result1 := imgprocessing.CombineMatrixesHorizontally([]*[][][3]uint8{matrix1Resized, histMatrix1Resized}, 5)
+	result1 := imgprocessing.CombineImagesHorizontally([]*imgdata.Image{img1Resized, histImg1Resized}, 5)
 
-	matrix2Resized := imgprocessing.ResizeNearestNeighbor(matrix2, 500, 500)
+	img2Resized := imgprocessing.ResizeImageNearestNeighbor(img2, 500, 500)
 
-	histMatrix2Resized := imgprocessing.ResizeNearestNeighbor(histMatrix2, 1500, 500)
+	histImg2Resized := imgprocessing.ResizeImageNearestNeighbor(histImg2, 1500, 500)
 
-	result2 := imgprocessing.CombineMatrixesHorizontally([]*[][][3]uint8{matrix2Resized, histMatrix2Resized}, 5)
+	result2 := imgprocessing.CombineImagesHorizontally([]*imgdata.Image{img2Resized, histImg2Resized}, 5)
 
-	combinedResult := imgprocessing.CombineMatrixesVertically([]*[][][3]uint8{result1, result2}, 15)
+	combinedResult := imgprocessing.CombineImagesVertically([]*imgdata.Image{result1, result2}, 15)
 
 	return combinedResult, nil
 }
+
+func CompareHistograms(matrix1 *[][][3]uint8, matrix2 *[][][3]uint8) (*[][][3]uint8, error) {
+	combinedResult, err := CompareImageHistograms(imgdata.FromMatrix(matrix1), imgdata.FromMatrix(matrix2))
+	if err != nil {
+		return nil, err
+	}
+
+	return combinedResult.ToMatrix(), nil
+}
+
+func init() {
+	imgprocessing.RegisterOperation(imgprocessing.Operation{
+		Name:  "histogram",
+		Arity: 1,
+		Apply: func(ctx context.Context, img *imgdata.Image, _ *imgdata.Image, params imgprocessing.Params) (*imgdata.Image, error) {
+			return GetImageHistRGB(img)
+		},
+	})
+
+	imgprocessing.RegisterOperation(imgprocessing.Operation{
+		Name:  "compare-histograms",
+		Arity: 2,
+		Apply: func(ctx context.Context, img1 *imgdata.Image, img2 *imgdata.Image, params imgprocessing.Params) (*imgdata.Image, error) {
+			return CompareImageHistograms(img1, img2)
+		},
+	})
+
+	imgprocessing.RegisterOperation(imgprocessing.Operation{
+		Name:  "equalize-and-compare-histograms",
+		Arity: 1,
+		Apply: func(ctx context.Context, img *imgdata.Image, _ *imgdata.Image, params imgprocessing.Params) (*imgdata.Image, error) {
+			equalizedImg := img.Copy()
+
+			err := imgprocessing.EqualizeImageHistogramContext(ctx, equalizedImg)
+			if err != nil {
+				return nil, err
+			}
+
+			return CompareImageHistograms(img, equalizedImg)
+		},
+	})
+}
@@ -0,0 +1,261 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"img-ops/imgerrors"
+)
+
+//parte que roda operações demoradas em segundo plano
+
+type Status string
+
+const (
+	StatusQueued  Status = "queued"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+type Job struct {
+	ID         string     `json:"id"`
+	Operation  string     `json:"operation"`
+	Status     Status     `json:"status"`
+	Progress   int        `json:"progress"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+func (job *Job) Finished() bool {
+	return job.Status == StatusDone || job.Status == StatusFailed
+}
+
+type Result struct {
+	ContentType string
+	Data        []byte
+
+	//chamada quando o resultado sai do store, para quem reservou memória para ele devolver
+	Release func()
+}
+
+func (result *Result) release() {
+	if result != nil && result.Release != nil {
+		result.Release()
+	}
+}
+
+//progress recebe a porcentagem concluída, de 0 a 100
+
+type Task func(ctx context.Context, progress func(percent int)) (*Result, error)
+
+var ErrQueueFull = imgerrors.New(imgerrors.CodeUnavailable, "job queue is full, try again later")
+
+type queuedTask struct {
+	id   string
+	task Task
+}
+
+type Manager struct {
+	store   Store
+	ttl     time.Duration
+	workers int
+	busy    int32
+	queue   chan queuedTask
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func NewManager(store Store, workers int, queueSize int, ttl time.Duration) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	manager := &Manager{
+		store:   store,
+		ttl:     ttl,
+		workers: workers,
+		queue:   make(chan queuedTask, queueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	for i := 0; i < workers; i++ {
+		manager.wg.Add(1)
+		go manager.work()
+	}
+
+	manager.wg.Add(1)
+	go manager.expire()
+
+	return manager
+}
+
+func newID() (string, error) {
+	id := make([]byte, 16)
+
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+func (manager *Manager) Submit(operation string, task Task) (*Job, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ID:        id,
+		Operation: operation,
+		Status:    StatusQueued,
+		CreatedAt: time.Now(),
+	}
+
+	err = manager.store.Create(job)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case manager.queue <- queuedTask{id: id, task: task}:
+		return job, nil
+	default:
+		manager.store.Delete(id)
+
+		return nil, ErrQueueFull
+	}
+}
+
+func (manager *Manager) Get(id string) (*Job, error) {
+	return manager.store.Get(id)
+}
+
+func (manager *Manager) Result(id string) (*Result, error) {
+	return manager.store.Result(id)
+}
+
+type Stats struct {
+	Workers   int `json:"workers"`
+	Busy      int `json:"busy"`
+	Queued    int `json:"queued"`
+	QueueSize int `json:"queueSize"`
+}
+
+//com a fila cheia novos jobs são recusados
+
+func (stats Stats) Saturated() bool {
+	return stats.Queued >= stats.QueueSize
+}
+
+func (manager *Manager) Stats() Stats {
+	return Stats{
+		Workers:   manager.workers,
+		Busy:      int(atomic.LoadInt32(&manager.busy)),
+		Queued:    len(manager.queue),
+		QueueSize: cap(manager.queue),
+	}
+}
+
+func (manager *Manager) work() {
+	defer manager.wg.Done()
+
+	for {
+		select {
+		case <-manager.ctx.Done():
+			return
+		case queued := <-manager.queue:
+			manager.run(queued)
+		}
+	}
+}
+
+func (manager *Manager) run(queued queuedTask) {
+	atomic.AddInt32(&manager.busy, 1)
+	defer atomic.AddInt32(&manager.busy, -1)
+
+	startedAt := time.Now()
+
+	manager.store.Update(queued.id, func(job *Job) {
+		job.Status = StatusRunning
+		job.StartedAt = &startedAt
+	})
+
+	progress := func(percent int) {
+		manager.store.Update(queued.id, func(job *Job) {
+			job.Progress = percent
+		})
+	}
+
+	result, err := queued.task(manager.ctx, progress)
+
+	if err == nil {
+		err = manager.store.SetResult(queued.id, result)
+		if err != nil {
+			result.release()
+		}
+	}
+
+	finishedAt := time.Now()
+	expiresAt := finishedAt.Add(manager.ttl)
+
+	manager.store.Update(queued.id, func(job *Job) {
+		job.FinishedAt = &finishedAt
+		job.ExpiresAt = &expiresAt
+
+		if err != nil {
+			job.Status = StatusFailed
+			job.Error = err.Error()
+			return
+		}
+
+		job.Status = StatusDone
+		job.Progress = 100
+	})
+}
+
+//jobs terminados são apagados depois do ttl
+
+func (manager *Manager) expire() {
+	defer manager.wg.Done()
+
+	interval := manager.ttl / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-manager.ctx.Done():
+			return
+		case now := <-ticker.C:
+			ids, err := manager.store.Expired(now)
+			if err != nil {
+				continue
+			}
+
+			for _, id := range ids {
+				manager.store.Delete(id)
+			}
+		}
+	}
+}
+
+//cancela os jobs em andamento e espera os workers pararem
+
+func (manager *Manager) Close() {
+	manager.cancel()
+	manager.wg.Wait()
+}
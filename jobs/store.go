@@ -0,0 +1,128 @@
+package jobs
+
+import (
+	"sync"
+	"time"
+
+	"img-ops/imgerrors"
+)
+
+//parte que guarda os jobs e seus resultados, outra implementação de Store pode guardar em disco e chamar o Release do resultado assim que ele sair da memória
+
+var ErrNotFound = imgerrors.New(imgerrors.CodeNotFound, "job not found")
+
+type Store interface {
+	Create(job *Job) error
+	Get(id string) (*Job, error)
+	Update(id string, update func(job *Job)) error
+	SetResult(id string, result *Result) error
+	Result(id string) (*Result, error)
+	Delete(id string) error
+	Expired(now time.Time) ([]string, error)
+}
+
+type MemoryStore struct {
+	mutex   sync.RWMutex
+	jobs    map[string]*Job
+	results map[string]*Result
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		jobs:    map[string]*Job{},
+		results: map[string]*Result{},
+	}
+}
+
+func (store *MemoryStore) Create(job *Job) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	jobCopy := *job
+	store.jobs[job.ID] = &jobCopy
+
+	return nil
+}
+
+//devolve uma cópia para que quem chamou não altere o job sem passar pelo Update
+
+func (store *MemoryStore) Get(id string) (*Job, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	job, exists := store.jobs[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	jobCopy := *job
+
+	return &jobCopy, nil
+}
+
+func (store *MemoryStore) Update(id string, update func(job *Job)) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	job, exists := store.jobs[id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	update(job)
+
+	return nil
+}
+
+func (store *MemoryStore) SetResult(id string, result *Result) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, exists := store.jobs[id]; !exists {
+		return ErrNotFound
+	}
+
+	store.results[id] = result
+
+	return nil
+}
+
+func (store *MemoryStore) Result(id string) (*Result, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	result, exists := store.results[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	return result, nil
+}
+
+func (store *MemoryStore) Delete(id string) error {
+	store.mutex.Lock()
+	result := store.results[id]
+
+	delete(store.jobs, id)
+	delete(store.results, id)
+	store.mutex.Unlock()
+
+	result.release()
+
+	return nil
+}
+
+func (store *MemoryStore) Expired(now time.Time) ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	ids := []string{}
+
+	for id, job := range store.jobs {
+		if job.ExpiresAt != nil && now.After(*job.ExpiresAt) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
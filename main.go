@@ -1,12 +1,29 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"os"
+
+	"img-ops/config"
 	"img-ops/server"
 )
 
 func main() {
-	fmt.Println("Running...")
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		os.Exit(2)
+	}
 
-	server.StartServer()
+	err = server.StartServer(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Server stopped: %v\n", err)
+		os.Exit(1)
+	}
 }
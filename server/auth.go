@@ -0,0 +1,160 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"img-ops/imgerrors"
+)
+
+//parte que identifica o cliente de cada requisição
+
+//ok é false quando a credencial não é do tipo que o authenticator conhece, assim o próximo pode tentar
+
+type authenticator func(credential string, now time.Time) (client string, ok bool, err error)
+
+const apiKeyHeader = "X-API-Key"
+
+const clientKey = "client"
+
+//o EventSource do navegador não manda cabeçalhos, então o token também é aceito na query
+
+func credentialOf(request *http.Request) string {
+	if apiKey := request.Header.Get(apiKeyHeader); apiKey != "" {
+		return apiKey
+	}
+
+	if authorization := request.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	return request.URL.Query().Get("access_token")
+}
+
+func newAPIKeyAuthenticator(apiKeys []string) authenticator {
+	type clientAPIKey struct {
+		client string
+		key    []byte
+	}
+
+	keys := []clientAPIKey{}
+
+	for _, apiKey := range apiKeys {
+		parts := strings.SplitN(apiKey, ":", 2)
+		keys = append(keys, clientAPIKey{client: parts[0], key: []byte(parts[1])})
+	}
+
+	return func(credential string, now time.Time) (string, bool, error) {
+		client := ""
+
+		//compara com todas as chaves para não revelar pelo tempo qual delas chegou perto
+		for _, key := range keys {
+			if subtle.ConstantTimeCompare(key.key, []byte(credential)) == 1 {
+				client = key.client
+			}
+		}
+
+		return client, client != "", nil
+	}
+}
+
+func tokenSignature(secret string, client string, expires string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(client + "." + expires))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func SignToken(secret string, client string, expires time.Time) string {
+	expiresStr := strconv.FormatInt(expires.Unix(), 10)
+
+	return client + "." + expiresStr + "." + tokenSignature(secret, client, expiresStr)
+}
+
+func newTokenAuthenticator(secret string) authenticator {
+	return func(credential string, now time.Time) (string, bool, error) {
+		parts := strings.Split(credential, ".")
+		if len(parts) != 3 || parts[0] == "" {
+			return "", false, nil
+		}
+
+		expires, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return "", false, nil
+		}
+
+		if !hmac.Equal([]byte(parts[2]), []byte(tokenSignature(secret, parts[0], parts[1]))) {
+			return "", true, imgerrors.New(imgerrors.CodeUnauthorized, "invalid token signature")
+		}
+
+		if now.Unix() > expires {
+			return "", true, imgerrors.New(imgerrors.CodeUnauthorized, "token expired")
+		}
+
+		return parts[0], true, nil
+	}
+}
+
+//as sondas do orquestrador não se autenticam
+
+var publicRoutes = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+func newAuthMiddleware(authenticators ...authenticator) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if publicRoutes[context.FullPath()] {
+			context.Next()
+			return
+		}
+
+		credential := credentialOf(context.Request)
+
+		if credential == "" {
+			sendUnauthorized(context, imgerrors.New(imgerrors.CodeUnauthorized, "missing API key or bearer token"))
+			return
+		}
+
+		for _, authenticate := range authenticators {
+			client, ok, err := authenticate(credential, time.Now())
+			if !ok {
+				continue
+			}
+
+			if err != nil {
+				sendUnauthorized(context, err)
+				return
+			}
+
+			context.Set(clientKey, client)
+			context.Next()
+			return
+		}
+
+		sendUnauthorized(context, imgerrors.New(imgerrors.CodeUnauthorized, "invalid API key or bearer token"))
+	}
+}
+
+func sendUnauthorized(context *gin.Context, err error) {
+	context.Header("WWW-Authenticate", `Bearer realm="img-ops"`)
+	sendError(context, err)
+}
+
+//sem autenticação o cliente é o IP de quem fez a requisição, que só vem dos cabeçalhos de proxy quando a conexão é de um proxy configurado em trusted-proxies
+
+func getClient(context *gin.Context) string {
+	if client := context.GetString(clientKey); client != "" {
+		return client
+	}
+
+	return "ip:" + context.ClientIP()
+}
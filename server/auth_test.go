@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"img-ops/config"
+	"img-ops/imgerrors"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+var testNow = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func serveTestRequest(t *testing.T, cfg config.Config, request *http.Request) *httptest.ResponseRecorder {
+	router, closeRouter, err := newRouter(cfg)
+	if err != nil {
+		t.Fatalf("building the router: %v", err)
+	}
+	defer closeRouter()
+
+	recorder := httptest.NewRecorder()
+
+	router.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func errorCodeOf(t *testing.T, recorder *httptest.ResponseRecorder) imgerrors.Code {
+	var response ErrorResponse
+
+	err := json.Unmarshal(recorder.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("decoding the error response %q: %v", recorder.Body.String(), err)
+	}
+
+	return response.Code
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	authenticate := newAPIKeyAuthenticator([]string{"alice:key-a", "bob:key:with:colons"})
+
+	cases := []struct {
+		name       string
+		credential string
+		wantClient string
+		wantOK     bool
+	}{
+		{name: "first key", credential: "key-a", wantClient: "alice", wantOK: true},
+		{name: "key with colons", credential: "key:with:colons", wantClient: "bob", wantOK: true},
+		{name: "unknown key", credential: "key-b"},
+		{name: "client name is not a key", credential: "alice"},
+		{name: "prefix of a key", credential: "key"},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			client, ok, err := authenticate(testCase.credential, testNow)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if client != testCase.wantClient || ok != testCase.wantOK {
+				t.Fatalf("got (%q, %t), want (%q, %t)", client, ok, testCase.wantClient, testCase.wantOK)
+			}
+		})
+	}
+}
+
+//ok false deixa o próximo authenticator tentar, ok true com erro recusa a requisição
+
+func TestTokenAuthenticator(t *testing.T) {
+	authenticate := newTokenAuthenticator(testSecret)
+
+	valid := SignToken(testSecret, "alice", testNow.Add(time.Hour))
+	signature := valid[strings.LastIndex(valid, ".")+1:]
+
+	cases := []struct {
+		name       string
+		credential string
+		wantClient string
+		wantOK     bool
+		wantErr    string
+	}{
+		{name: "valid token", credential: valid, wantClient: "alice", wantOK: true},
+		{name: "expires this second", credential: SignToken(testSecret, "alice", testNow), wantClient: "alice", wantOK: true},
+		{name: "expired", credential: SignToken(testSecret, "alice", testNow.Add(-time.Second)), wantOK: true, wantErr: "token expired"},
+		{name: "signed with another secret", credential: SignToken("another secret that is long enough", "alice", testNow.Add(time.Hour)), wantOK: true, wantErr: "invalid token signature"},
+		{name: "client changed after signing", credential: "mallory" + valid[len("alice"):], wantOK: true, wantErr: "invalid token signature"},
+		{name: "expiry changed after signing", credential: "alice." + strconv.FormatInt(testNow.Add(48*time.Hour).Unix(), 10) + "." + signature, wantOK: true, wantErr: "invalid token signature"},
+		{name: "API key", credential: "key-a"},
+		{name: "expiry is not a number", credential: "alice.tomorrow.signature"},
+		{name: "empty client", credential: ".1.signature"},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			client, ok, err := authenticate(testCase.credential, testNow)
+
+			if client != testCase.wantClient || ok != testCase.wantOK {
+				t.Fatalf("got (%q, %t), want (%q, %t)", client, ok, testCase.wantClient, testCase.wantOK)
+			}
+
+			if testCase.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			typedErr, isTyped := imgerrors.As(err)
+			if !isTyped || typedErr.Code != imgerrors.CodeUnauthorized || typedErr.Message != testCase.wantErr {
+				t.Fatalf("got error %v, want unauthorized %q", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnauthorizedResponses(t *testing.T) {
+	cfg := config.Default()
+	cfg.APIKeys = []string{"alice:key-a"}
+	cfg.AuthSecret = testSecret
+
+	cases := []struct {
+		name       string
+		path       string
+		header     string
+		value      string
+		wantStatus int
+	}{
+		{name: "no credential", path: "/capabilities", wantStatus: http.StatusUnauthorized},
+		{name: "unknown API key", path: "/capabilities", header: apiKeyHeader, value: "key-b", wantStatus: http.StatusUnauthorized},
+		{name: "API key", path: "/capabilities", header: apiKeyHeader, value: "key-a", wantStatus: http.StatusOK},
+		{name: "bearer token", path: "/capabilities", header: "Authorization", value: "Bearer " + SignToken(testSecret, "alice", time.Now().Add(time.Hour)), wantStatus: http.StatusOK},
+		{name: "expired bearer token", path: "/capabilities", header: "Authorization", value: "Bearer " + SignToken(testSecret, "alice", time.Now().Add(-time.Hour)), wantStatus: http.StatusUnauthorized},
+		{name: "token in the query", path: "/capabilities?access_token=" + SignToken(testSecret, "alice", time.Now().Add(time.Hour)), wantStatus: http.StatusOK},
+		{name: "public route", path: "/healthz", wantStatus: http.StatusOK},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, testCase.path, nil)
+			if testCase.header != "" {
+				request.Header.Set(testCase.header, testCase.value)
+			}
+
+			recorder := serveTestRequest(t, cfg, request)
+
+			if recorder.Code != testCase.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", recorder.Code, testCase.wantStatus, recorder.Body.String())
+			}
+
+			if testCase.wantStatus != http.StatusUnauthorized {
+				return
+			}
+
+			if challenge := recorder.Header().Get("WWW-Authenticate"); challenge != `Bearer realm="img-ops"` {
+				t.Fatalf("got WWW-Authenticate %q", challenge)
+			}
+
+			if code := errorCodeOf(t, recorder); code != imgerrors.CodeUnauthorized {
+				t.Fatalf("got code %s, want %s", code, imgerrors.CodeUnauthorized)
+			}
+		})
+	}
+}
@@ -0,0 +1,591 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	stdcontext "context"
+	"encoding/json"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"img-ops/imgconversion"
+	"img-ops/imgerrors"
+	"img-ops/imgprocessing"
+)
+
+//parte que aplica a mesma operação a várias imagens, enviadas num ZIP no campo archive ou em vários campos img
+
+type batchInput struct {
+	name string
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+type batchFile struct {
+	Name   string         `json:"name"`
+	Output string         `json:"output,omitempty"`
+	Status string         `json:"status"`
+	Size   int            `json:"size,omitempty"`
+	Error  *ErrorResponse `json:"error,omitempty"`
+
+	format string
+	data   []byte
+}
+
+type batchManifest struct {
+	RequestID string      `json:"requestId"`
+	Operation string      `json:"operation"`
+	Total     int         `json:"total"`
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+	Files     []batchFile `json:"files"`
+}
+
+//arquivos que o macOS e outros sistemas colocam nos ZIPs não são imagens
+
+func skipArchiveEntry(file *zip.File) bool {
+	base := path.Base(file.Name)
+
+	return file.FileInfo().IsDir() || strings.HasPrefix(file.Name, "__MACOSX/") || strings.HasPrefix(base, ".")
+}
+
+func addArchiveInputs(inputs []batchInput, archive io.ReaderAt, size int64) ([]batchInput, error) {
+	zipReader, err := zip.NewReader(archive, size)
+	if err != nil {
+		return nil, &imgerrors.Error{Code: imgerrors.CodeInvalidParameter, Message: "archive is not a valid ZIP file: " + err.Error(), Param: "archive", Err: err}
+	}
+
+	for _, file := range zipReader.File {
+		if !skipArchiveEntry(file) {
+			inputs = append(inputs, batchInput{name: file.Name, size: int64(file.UncompressedSize64), open: file.Open})
+		}
+	}
+
+	return inputs, nil
+}
+
+func getMultipartBatchInputs(context *gin.Context) ([]batchInput, []io.Closer, error) {
+	form, err := context.MultipartForm()
+	if err != nil {
+		return nil, nil, uploadError("archive", err)
+	}
+
+	inputs := []batchInput{}
+	archives := []io.Closer{}
+
+	for _, fileHeader := range form.File["img"] {
+		fileHeader := fileHeader
+
+		inputs = append(inputs, batchInput{name: fileHeader.Filename, size: fileHeader.Size, open: func() (io.ReadCloser, error) {
+			return fileHeader.Open()
+		}})
+	}
+
+	for _, fileHeader := range form.File["archive"] {
+		archive, err := fileHeader.Open()
+		if err != nil {
+			return nil, archives, uploadError("archive", err)
+		}
+		archives = append(archives, archive)
+
+		inputs, err = addArchiveInputs(inputs, archive, fileHeader.Size)
+		if err != nil {
+			return nil, archives, err
+		}
+	}
+
+	return inputs, archives, nil
+}
+
+//no JSON img pode ser uma string ou uma lista delas, e archive é o ZIP em base64
+
+func getJSONBatchInputs(context *gin.Context) ([]batchInput, error) {
+	fields, err := getJSONBody(context)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := []batchInput{}
+
+	if raw, exists := fields["img"]; exists && string(raw) != "null" {
+		var images []string
+
+		var image string
+		if json.Unmarshal(raw, &image) == nil {
+			images = []string{image}
+		} else if json.Unmarshal(raw, &images) != nil {
+			return nil, imgerrors.InvalidParam("img", "img must be a base64 string or a list of them")
+		}
+
+		for i, image := range images {
+			name := "img"
+			if len(images) > 1 {
+				name = "img-" + strconv.Itoa(i+1)
+			}
+
+			image := image
+
+			inputs = append(inputs, batchInput{name: name, open: func() (io.ReadCloser, error) {
+				reader, err := base64Reader(name, image)
+				if err != nil {
+					return nil, err
+				}
+
+				return io.NopCloser(reader), nil
+			}})
+		}
+	}
+
+	if _, exists := fields["archive"]; exists {
+		reader, err := jsonUpload(context, "archive")
+		if err != nil {
+			return nil, err
+		}
+
+		archive, err := readBatchBody(context, "archive", reader)
+		if err != nil {
+			return nil, err
+		}
+
+		inputs, err = addArchiveInputs(inputs, bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return inputs, nil
+}
+
+//o ZIP precisa estar inteiro na memória, então a memória é reservada antes de cada vez que o buffer cresce e um corpo maior que batch-max-total-size é recusado sem ler o resto
+
+const minBatchBodyChunkSize = 64 << 10
+
+func readBatchBody(context *gin.Context, name string, reader io.Reader) ([]byte, error) {
+	maxSize := getConfig(context).BatchMaxTotalSize
+	requestReservation, hasReservation := getReservation(context)
+	ctx := context.Request.Context()
+
+	data := []byte{}
+
+	for {
+		if len(data) == cap(data) {
+			//um byte além do limite basta para saber que o corpo passou dele
+			newCap := int64(2 * cap(data))
+			if newCap < minBatchBodyChunkSize {
+				newCap = minBatchBodyChunkSize
+			}
+			if newCap > maxSize+1 {
+				newCap = maxSize + 1
+			}
+
+			//o buffer antigo só some quando o coletor de lixo passar, então continua na conta
+			if hasReservation {
+				err := requestReservation.reserve(ctx, newCap)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			grown := make([]byte, len(data), newCap)
+			copy(grown, data)
+			data = grown
+		}
+
+		n, err := reader.Read(data[len(data):cap(data)])
+		data = data[:len(data)+n]
+
+		if int64(len(data)) > maxSize {
+			return nil, &imgerrors.Error{Code: imgerrors.CodeTooLarge, Message: name + " is larger than the allowed size", Param: name}
+		}
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, uploadError(name, err)
+		}
+	}
+}
+
+//o corpo cru pode ser um ZIP ou uma imagem só
+
+func getRawBatchInputs(context *gin.Context) ([]batchInput, error) {
+	data, err := readBatchBody(context, "archive", context.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return addArchiveInputs([]batchInput{}, bytes.NewReader(data), int64(len(data)))
+	}
+
+	if len(data) == 0 {
+		return []batchInput{}, nil
+	}
+
+	return []batchInput{{name: "img", size: int64(len(data)), open: func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}}}, nil
+}
+
+func getBatchInputs(context *gin.Context) ([]batchInput, func(), error) {
+	var inputs []batchInput
+	var archives []io.Closer
+	var err error
+
+	switch bodyKind(context) {
+	case bodyJSON:
+		inputs, err = getJSONBatchInputs(context)
+	case bodyRaw:
+		inputs, err = getRawBatchInputs(context)
+	default:
+		inputs, archives, err = getMultipartBatchInputs(context)
+	}
+
+	closeArchives := func() {
+		for _, archive := range archives {
+			archive.Close()
+		}
+	}
+
+	if err != nil {
+		closeArchives()
+		return nil, nil, err
+	}
+
+	if len(inputs) == 0 {
+		closeArchives()
+		return nil, nil, imgerrors.InvalidParam("archive", "send a ZIP file in archive or one or more images in img")
+	}
+
+	maxFiles := getConfig(context).BatchMaxFiles
+
+	if len(inputs) > maxFiles {
+		closeArchives()
+		return nil, nil, &imgerrors.Error{Code: imgerrors.CodeTooLarge, Message: "batch has " + strconv.Itoa(len(inputs)) + " images, the limit is " + strconv.Itoa(maxFiles), Param: "archive"}
+	}
+
+	var totalSize int64
+
+	for _, input := range inputs {
+		totalSize += input.size
+	}
+
+	if totalSize > getConfig(context).BatchMaxTotalSize {
+		closeArchives()
+		return nil, nil, errBatchTooLarge
+	}
+
+	return inputs, closeArchives, nil
+}
+
+//o nome de saída mantém as pastas do ZIP, troca a extensão e não pode sair da raiz
+
+func batchOutputName(name string, format string, used map[string]bool) string {
+	name = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, `\`, "/")), "/")
+	name = strings.TrimSuffix(name, path.Ext(name))
+
+	output := name + "." + format
+
+	for i := 2; used[output]; i++ {
+		output = name + "-" + strconv.Itoa(i) + "." + format
+	}
+
+	used[output] = true
+
+	return output
+}
+
+var errBatchFileTooLarge = &imgerrors.Error{Code: imgerrors.CodeTooLarge, Message: "image is larger than the allowed size", Param: "archive"}
+
+var errBatchTooLarge = &imgerrors.Error{Code: imgerrors.CodeTooLarge, Message: "images of the batch together are larger than the allowed size", Param: "archive"}
+
+//o tamanho declarado no ZIP pode ser falso, então os bytes são contados enquanto a imagem é decodificada
+
+type batchEntryReader struct {
+	reader    io.Reader
+	remaining int64
+	total     *int64
+	maxTotal  int64
+	err       error
+}
+
+func (reader *batchEntryReader) Read(p []byte) (int, error) {
+	if reader.err != nil {
+		return 0, reader.err
+	}
+
+	n, err := reader.reader.Read(p)
+
+	reader.remaining -= int64(n)
+
+	switch {
+	case reader.remaining < 0:
+		reader.err = errBatchFileTooLarge
+	case atomic.AddInt64(reader.total, int64(n)) > reader.maxTotal:
+		reader.err = errBatchTooLarge
+	case err != nil && err != io.EOF:
+		reader.err = err
+	}
+
+	if reader.err != nil {
+		return n, reader.err
+	}
+
+	return n, err
+}
+
+//cada imagem reserva e libera a própria memória, assim um lote grande não precisa caber inteiro no orçamento
+
+func processBatchInput(context *gin.Context, ctx stdcontext.Context, input batchInput, total *int64, loadOptions imgconversion.LoadOptions, encodeOptions imgconversion.EncodeOptions, secondAnimation *imgconversion.Animation, operation twoImageOperation) (*batchFile, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	cfg := getConfig(context)
+
+	if input.size > cfg.BatchMaxFileSize {
+		return nil, errBatchFileTooLarge
+	}
+
+	requestReservation, hasReservation := getReservation(context)
+	fileReservation := &reservation{}
+
+	if hasReservation {
+		fileReservation.budget = requestReservation.budget
+		defer fileReservation.releaseAll()
+
+		loadOptions.Reserve = func(bytes int64) error {
+			return fileReservation.reserve(ctx, bytes)
+		}
+	}
+
+	reader, err := input.open()
+	if err != nil {
+		return nil, imgerrors.Wrap(imgerrors.CodeInvalidImage, err)
+	}
+	defer reader.Close()
+
+	//a imagem é decodificada direto do ZIP, assim as dimensões e o orçamento são verificados antes de ler os pixels
+	entry := &batchEntryReader{reader: reader, remaining: cfg.BatchMaxFileSize, total: total, maxTotal: cfg.BatchMaxTotalSize}
+
+	animation, metadata, err := decodeAnimation(context, entry, loadOptions)
+	if entry.err != nil {
+		if _, typed := imgerrors.As(entry.err); typed {
+			return nil, entry.err
+		}
+
+		return nil, imgerrors.Wrap(imgerrors.CodeInvalidImage, entry.err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := applyToFramePairs(ctx, animation, secondAnimation, operation)
+	if err != nil {
+		return nil, err
+	}
+
+	if encodeOptions.Format == "" {
+		encodeOptions.Format = imgconversion.FormatPNG
+
+		if result.IsAnimated() {
+			encodeOptions.Format = imgconversion.FormatGIF
+		}
+	}
+
+	encodeOptions.Exif = metadata.Exif()
+
+	data, err := encodeAnimation(result, encodeOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	//o resultado fica na memória até o ZIP de saída ser escrito, então passa a contar na reserva da requisição
+	if hasReservation {
+		fileReservation.releaseAll()
+
+		err = requestReservation.reserve(ctx, int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &batchFile{Name: input.name, Status: "ok", Size: len(data), format: encodeOptions.Format, data: data}, nil
+}
+
+func writeBatchArchive(writer io.Writer, manifest batchManifest) error {
+	archive := zip.NewWriter(writer)
+	now := time.Now()
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	manifestWriter, err := archive.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return err
+	}
+
+	_, err = manifestWriter.Write(manifestJSON)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range manifest.Files {
+		if file.Status != "ok" {
+			continue
+		}
+
+		//esses formatos já são comprimidos
+		method := zip.Deflate
+		if file.format == imgconversion.FormatPNG || file.format == imgconversion.FormatJPEG || file.format == imgconversion.FormatGIF {
+			method = zip.Store
+		}
+
+		fileWriter, err := archive.CreateHeader(&zip.FileHeader{Name: file.Output, Method: method, Modified: now})
+		if err != nil {
+			return err
+		}
+
+		_, err = fileWriter.Write(file.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func handleBatch(context *gin.Context) {
+	cfg := getConfig(context)
+
+	asBase64, err := wantsBase64(context)
+	if err != nil {
+		sendError(context, err)
+		return
+	}
+
+	operation, needsSecondImage, err := getOperationFromForm(context)
+	if err != nil {
+		sendError(context, err)
+		return
+	}
+
+	inputs, closeArchives, err := getBatchInputs(context)
+	if err != nil {
+		sendError(context, err)
+		return
+	}
+	defer closeArchives()
+
+	secondAnimation := imgconversion.NewStaticAnimation(nil)
+
+	if needsSecondImage {
+		secondAnimation, err = loadAnimationFromParams(context, "img2")
+		if err != nil {
+			sendError(context, err)
+			return
+		}
+	}
+
+	loadOptions, err := getLoadOptionsFromParams(context)
+	if err != nil {
+		sendError(context, err)
+		return
+	}
+
+	//formato vazio quer dizer o padrão de cada imagem, PNG ou GIF se for animada
+	encodeOptions, err := getEncodeOptionsFromParams(context, "")
+	if err != nil {
+		sendError(context, err)
+		return
+	}
+
+	ctx := context.Request.Context()
+
+	//o progresso é contado por imagem terminada, não pelas linhas de cada uma
+	fileCtx := imgprocessing.WithProgress(ctx, func(done int, total int) {})
+
+	files := make([]batchFile, len(inputs))
+	var totalSize int64
+	workers := make(chan struct{}, cfg.BatchWorkers)
+	finished := 0
+	var finishedMutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for i, input := range inputs {
+		workers <- struct{}{}
+		wg.Add(1)
+
+		go func(i int, input batchInput) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+
+			file, err := processBatchInput(context, fileCtx, input, &totalSize, loadOptions, encodeOptions, secondAnimation, operation)
+			if err != nil {
+				errResponse := newErrorResponse(context, classifyError(context, err))
+				errResponse.RequestID = ""
+
+				file = &batchFile{Name: input.name, Status: "failed", Error: &errResponse}
+			}
+
+			files[i] = *file
+
+			finishedMutex.Lock()
+			finished++
+			imgprocessing.ReportProgress(ctx, finished, len(inputs))
+			finishedMutex.Unlock()
+		}(i, input)
+	}
+
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		sendError(context, ctx.Err())
+		return
+	}
+
+	//o nome já foi lido e validado por getOperationFromForm
+	opName, _ := formValue(context, "op")
+
+	manifest := batchManifest{
+		RequestID: getRequestID(context),
+		Operation: opName,
+		Total:     len(files),
+		Files:     files,
+	}
+
+	used := map[string]bool{"manifest.json": true}
+
+	for i := range manifest.Files {
+		if manifest.Files[i].Status != "ok" {
+			manifest.Failed++
+			continue
+		}
+
+		manifest.Succeeded++
+		manifest.Files[i].Output = batchOutputName(manifest.Files[i].Name, manifest.Files[i].format, used)
+	}
+
+	if !asBase64 {
+		context.Header("Content-Disposition", `attachment; filename="results.zip"`)
+	}
+
+	err = sendResult(context, "application/zip", asBase64, func(writer io.Writer) error {
+		return writeBatchArchive(writer, manifest)
+	})
+	if err != nil {
+		context.Writer.Header().Del("Content-Disposition")
+		sendStreamError(context, err)
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//parte que limita a memória usada por todas as requisições juntas
+
+type BudgetError struct {
+	Needed    int64
+	Available int64
+	Limit     int64
+}
+
+func (err *BudgetError) Error() string {
+	if err.Needed > err.Limit {
+		return fmt.Sprintf("image needs an estimated %d bytes, more than the memory budget of %d", err.Needed, err.Limit)
+	}
+
+	return fmt.Sprintf("image needs an estimated %d bytes but only %d of the memory budget are free, try again later", err.Needed, err.Available)
+}
+
+//quando não há memória livre a requisição espera até wait antes de ser recusada
+
+type memoryBudget struct {
+	mutex   sync.Mutex
+	limit   int64
+	used    int64
+	wait    time.Duration
+	changed chan struct{}
+}
+
+func newMemoryBudget(limit int64, wait time.Duration) *memoryBudget {
+	return &memoryBudget{
+		limit:   limit,
+		wait:    wait,
+		changed: make(chan struct{}),
+	}
+}
+
+func (budget *memoryBudget) acquire(ctx context.Context, bytes int64) error {
+	if budget.limit <= 0 {
+		return nil
+	}
+
+	if bytes > budget.limit {
+		return &BudgetError{Needed: bytes, Available: budget.limit, Limit: budget.limit}
+	}
+
+	timer := time.NewTimer(budget.wait)
+	defer timer.Stop()
+
+	for {
+		budget.mutex.Lock()
+
+		if budget.used+bytes <= budget.limit {
+			budget.used += bytes
+			budget.mutex.Unlock()
+
+			return nil
+		}
+
+		available := budget.limit - budget.used
+		changed := budget.changed
+
+		budget.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return &BudgetError{Needed: bytes, Available: available, Limit: budget.limit}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (budget *memoryBudget) usage() (used int64, limit int64) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+
+	return budget.used, budget.limit
+}
+
+func (budget *memoryBudget) release(bytes int64) {
+	if budget.limit <= 0 || bytes == 0 {
+		return
+	}
+
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+
+	budget.used -= bytes
+
+	//acorda todas as requisições esperando para tentarem de novo
+	close(budget.changed)
+	budget.changed = make(chan struct{})
+}
+
+//tudo que uma requisição reserva é devolvido quando ela termina
+
+type reservation struct {
+	mutex  sync.Mutex
+	budget *memoryBudget
+	bytes  int64
+}
+
+func (r *reservation) reserve(ctx context.Context, bytes int64) error {
+	err := r.budget.acquire(ctx, bytes)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.bytes += bytes
+	r.mutex.Unlock()
+
+	return nil
+}
+
+func (r *reservation) releaseAll() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.budget.release(r.bytes)
+	r.bytes = 0
+}
+
+//devolve o que passa de bytes e mantém o resto reservado
+
+func (r *reservation) shrink(bytes int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if bytes >= r.bytes {
+		return
+	}
+
+	r.budget.release(r.bytes - bytes)
+	r.bytes = bytes
+}
+
+const reservationKey = "reservation"
+
+func newMemoryBudgetMiddleware(budget *memoryBudget) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		r := &reservation{budget: budget}
+
+		context.Set(reservationKey, r)
+
+		defer r.releaseAll()
+
+		context.Next()
+	}
+}
+
+func getReservation(context *gin.Context) (*reservation, bool) {
+	value, exists := context.Get(reservationKey)
+	if !exists {
+		return nil, false
+	}
+
+	return value.(*reservation), true
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"img-ops/cache"
+	"img-ops/config"
+	"img-ops/imgconversion"
+	"img-ops/imgerrors"
+)
+
+//parte que evita refazer operações já feitas, a chave junta as imagens decodificadas, a operação e as opções de saída
+
+const resultCacheKey = "resultCache"
+
+func newResultCache(cfg config.Config) (*cache.Cache, error) {
+	tiers := []cache.Tier{}
+
+	if cfg.CacheSize > 0 {
+		tiers = append(tiers, cache.NewMemoryTier(cfg.CacheSize))
+	}
+
+	if cfg.CacheDir != "" {
+		diskTier, err := cache.NewDiskTier(cfg.CacheDir, cfg.CacheDiskSize)
+		if err != nil {
+			return nil, err
+		}
+
+		tiers = append(tiers, diskTier)
+	}
+
+	return cache.New(tiers...), nil
+}
+
+func newCacheMiddleware(resultCache *cache.Cache) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		context.Set(resultCacheKey, resultCache)
+		context.Next()
+	}
+}
+
+func getResultCache(context *gin.Context) (*cache.Cache, bool) {
+	value, exists := context.Get(resultCacheKey)
+	if !exists {
+		return nil, false
+	}
+
+	return value.(*cache.Cache), true
+}
+
+//o resultado é copiado enquanto vai para o cliente, se passar do que cabe no cache a cópia é descartada
+
+type cappedBuffer struct {
+	bytes.Buffer
+	limit      int64
+	overflowed bool
+}
+
+func (buf *cappedBuffer) Write(p []byte) (int, error) {
+	if buf.overflowed {
+		return len(p), nil
+	}
+
+	if int64(buf.Len()+len(p)) > buf.limit {
+		buf.overflowed = true
+		buf.Buffer = bytes.Buffer{}
+
+		return len(p), nil
+	}
+
+	return buf.Buffer.Write(p)
+}
+
+func maxCacheEntrySize(cfg config.Config) int64 {
+	if cfg.CacheDir != "" && cfg.CacheDiskSize > cfg.CacheSize {
+		return cfg.CacheDiskSize
+	}
+
+	return cfg.CacheSize
+}
+
+//o hash é dos pixels depois de decodificados, então a mesma imagem em outro formato ou com outra compressão cai na mesma chave
+
+func hashAnimation(h hash.Hash, animation *imgconversion.Animation) {
+	fmt.Fprintf(h, "animation %d %v %v %d\n", len(animation.Frames), animation.Delays, animation.Disposals, animation.LoopCount)
+
+	for _, frame := range animation.Frames {
+		fmt.Fprintf(h, "frame %d %d %d %d %d\n", frame.Width, frame.Height, frame.Channels, frame.Depth, frame.AlphaMode)
+
+		rowSize := frame.Width * frame.Channels * frame.Depth / 8
+
+		for y := 0; y < frame.Height; y++ {
+			h.Write(frame.Pix[y*frame.Stride : y*frame.Stride+rowSize])
+		}
+	}
+}
+
+func getResultKey(context *gin.Context, identity string, inputs ...*imgconversion.Animation) (string, error) {
+	//sem formato pedido o padrão depende do resultado ser animado, o que já é decidido pelas entradas
+	options, err := getEncodeOptionsFromParams(context, "")
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+
+	fmt.Fprintf(h, "%q %q %d %d %t %t %q\n", identity, options.Format, options.Quality, options.Colors, options.Dither, options.ASCII, options.TIFFCompression)
+
+	//os metadados da entrada vão para a saída
+	if value, exists := context.Get(metadataKey); exists {
+		exif := value.(*imgconversion.Metadata).Exif()
+
+		fmt.Fprintf(h, "exif %d\n", len(exif))
+		h.Write(exif)
+	}
+
+	for _, input := range inputs {
+		hashAnimation(h, input)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+//identity descreve a operação e seus parâmetros, as imagens com os nomes em names são lidas aqui e compute só é chamada quando o resultado não está no cache
+
+func sendCachedAnimation(context *gin.Context, identity string, names []string, compute func(inputs []*imgconversion.Animation) (*imgconversion.Animation, error)) {
+	asBase64, err := wantsBase64(context)
+	if err != nil {
+		sendError(context, err)
+		return
+	}
+
+	inputs, err := loadAnimationsFromStream(context, names...)
+	if err != nil {
+		sendError(context, err)
+		return
+	}
+
+	key, err := getResultKey(context, identity, inputs...)
+	if err != nil {
+		sendError(context, err)
+		return
+	}
+
+	//o mesmo resultado em base64 é outra representação e precisa de outro ETag
+	etag := `"` + key + `"`
+	if asBase64 {
+		etag = `"` + key + `-base64"`
+	}
+
+	context.Header("ETag", etag)
+
+	if etagMatches(context.GetHeader("If-None-Match"), etag) {
+		cacheRequests.add(1, "not_modified")
+
+		//fora de GET e HEAD a RFC 7232 pede 412 quando o If-None-Match bate
+		if context.Request.Method == http.MethodGet || context.Request.Method == http.MethodHead {
+			context.AbortWithStatus(http.StatusNotModified)
+			return
+		}
+
+		sendError(context, imgerrors.New(imgerrors.CodePreconditionFailed, "the result matches the ETag in If-None-Match"))
+		return
+	}
+
+	resultCache, cacheEnabled := getResultCache(context)
+
+	if cacheEnabled {
+		if entry, tier, exists := resultCache.Get(key); exists {
+			cacheRequests.add(1, "hit_"+tier)
+			context.Header("X-Cache", "HIT")
+
+			err = sendResult(context, entry.ContentType, asBase64, func(writer io.Writer) error {
+				_, err := writer.Write(entry.Data)
+				return err
+			})
+			if err != nil {
+				sendStreamError(context, err)
+			}
+			return
+		}
+
+		cacheRequests.add(1, "miss")
+		context.Header("X-Cache", "MISS")
+	}
+
+	result, err := compute(inputs)
+	if err != nil {
+		sendError(context, err)
+		return
+	}
+
+	options, err := getAnimationEncodeOptions(context, result.IsAnimated())
+	if err != nil {
+		sendError(context, err)
+		return
+	}
+
+	var cached *cappedBuffer
+	var tee io.Writer
+
+	if cacheEnabled {
+		cached = &cappedBuffer{limit: maxCacheEntrySize(getConfig(context))}
+		tee = cached
+	}
+
+	err = streamAnimation(context, result, options, asBase64, tee)
+	if err != nil {
+		sendStreamError(context, err)
+		return
+	}
+
+	if cached == nil || cached.overflowed {
+		return
+	}
+
+	err = resultCache.Put(key, &cache.Entry{ContentType: imgconversion.ContentTypeOf(options.Format), Data: cached.Bytes()})
+	if err != nil {
+		logWith("warn", logFields{"requestId": getRequestID(context), "error": err.Error()}, "could not store result in the cache")
+	}
+}
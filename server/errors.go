@@ -0,0 +1,150 @@
+package server
+
+import (
+	stdcontext "context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"img-ops/imgerrors"
+)
+
+//parte que transforma os erros em respostas JSON com o status HTTP certo
+
+type ErrorResponse struct {
+	Code      imgerrors.Code `json:"code"`
+	Message   string         `json:"message"`
+	Param     string         `json:"param,omitempty"`
+	RequestID string         `json:"requestId,omitempty"`
+}
+
+var statusOfCode = map[imgerrors.Code]int{
+	imgerrors.CodeInvalidParameter:   http.StatusBadRequest,
+	imgerrors.CodeInvalidImage:       http.StatusBadRequest,
+	imgerrors.CodeUnsupportedFormat:  http.StatusUnsupportedMediaType,
+	imgerrors.CodeDimensionMismatch:  http.StatusUnprocessableEntity,
+	imgerrors.CodeTooLarge:           http.StatusRequestEntityTooLarge,
+	imgerrors.CodeNotFound:           http.StatusNotFound,
+	imgerrors.CodeConflict:           http.StatusConflict,
+	imgerrors.CodePreconditionFailed: http.StatusPreconditionFailed,
+	imgerrors.CodeUnauthorized:       http.StatusUnauthorized,
+	imgerrors.CodeRateLimited:        http.StatusTooManyRequests,
+	imgerrors.CodeUnavailable:        http.StatusServiceUnavailable,
+	imgerrors.CodeTimeout:            http.StatusServiceUnavailable,
+	imgerrors.CodeInternal:           http.StatusInternalServerError,
+}
+
+func statusOf(code imgerrors.Code) int {
+	if status, exists := statusOfCode[code]; exists {
+		return status
+	}
+
+	return http.StatusInternalServerError
+}
+
+//erros de fora do projeto que têm um significado conhecido ganham um tipo aqui
+
+func classifyError(context *gin.Context, err error) *imgerrors.Error {
+	if typedErr, ok := imgerrors.As(err); ok {
+		return typedErr
+	}
+
+	var budgetErr *BudgetError
+	if errors.As(err, &budgetErr) {
+		return &imgerrors.Error{Code: imgerrors.CodeTooLarge, Message: err.Error(), Param: "memory", Err: err}
+	}
+
+	if errors.Is(err, stdcontext.DeadlineExceeded) {
+		return imgerrors.New(imgerrors.CodeTimeout, "request took longer than "+getConfig(context).RequestTimeout.String())
+	}
+
+	//o Go 1.18 ainda não tem um tipo para esse erro do http.MaxBytesReader
+	if strings.Contains(err.Error(), "http: request body too large") {
+		return &imgerrors.Error{Code: imgerrors.CodeTooLarge, Message: "request body is larger than the allowed size", Param: "body", Err: err}
+	}
+
+	return &imgerrors.Error{Code: imgerrors.CodeInternal, Message: "internal server error", Err: err}
+}
+
+func newErrorResponse(context *gin.Context, typedErr *imgerrors.Error) ErrorResponse {
+	return ErrorResponse{
+		Code:      typedErr.Code,
+		Message:   typedErr.Message,
+		Param:     typedErr.Param,
+		RequestID: getRequestID(context),
+	}
+}
+
+//o código fica no contexto para os logs e as métricas da requisição
+
+const errorCodeKey = "errorCode"
+
+func sendError(context *gin.Context, err error) {
+	if errors.Is(err, stdcontext.Canceled) {
+		context.Set(errorCodeKey, "cancelled")
+		logWith("info", logFields{"requestId": getRequestID(context)}, "request cancelled by the client")
+
+		//499 é o código usado pelo nginx para cliente que fechou a conexão
+		context.AbortWithStatus(499)
+		return
+	}
+
+	typedErr := classifyError(context, err)
+	status := statusOf(typedErr.Code)
+
+	context.Set(errorCodeKey, string(typedErr.Code))
+
+	fields := logFields{"requestId": getRequestID(context), "code": typedErr.Code, "status": status, "error": err.Error()}
+
+	if status >= http.StatusInternalServerError {
+		logWith("error", fields, "request failed")
+	} else {
+		logWith("warn", fields, "request rejected")
+	}
+
+	var budgetErr *BudgetError
+	if errors.As(err, &budgetErr) && budgetErr.Needed <= budgetErr.Limit {
+		context.Header("Retry-After", "5")
+	}
+
+	context.AbortWithStatusJSON(status, newErrorResponse(context, typedErr))
+}
+
+func uploadError(name string, err error) error {
+	if errors.Is(err, http.ErrMissingFile) {
+		return imgerrors.InvalidParam(name, "missing image upload "+name)
+	}
+
+	if strings.Contains(err.Error(), "http: request body too large") {
+		return err
+	}
+
+	var base64Err base64.CorruptInputError
+	if errors.As(err, &base64Err) {
+		return &imgerrors.Error{Code: imgerrors.CodeInvalidParameter, Message: name + " is not valid base64: " + err.Error(), Param: name, Err: err}
+	}
+
+	return &imgerrors.Error{Code: imgerrors.CodeInvalidParameter, Message: "invalid multipart upload: " + err.Error(), Param: name, Err: err}
+}
+
+func handleNoRoute(context *gin.Context) {
+	sendError(context, imgerrors.New(imgerrors.CodeNotFound, "no route for "+context.Request.Method+" "+context.Request.URL.Path))
+}
+
+func handlePanic(context *gin.Context, recovered interface{}) {
+	//o net/http sabe fechar a conexão sem registrar nada
+	if recovered == http.ErrAbortHandler {
+		panic(recovered)
+	}
+
+	context.Set(errorCodeKey, string(imgerrors.CodeInternal))
+
+	logWith("error", logFields{"requestId": getRequestID(context), "panic": fmt.Sprint(recovered), "stack": string(debug.Stack())}, "request panicked")
+
+	context.AbortWithStatusJSON(http.StatusInternalServerError, newErrorResponse(context, imgerrors.New(imgerrors.CodeInternal, "internal server error")))
+}
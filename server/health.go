@@ -0,0 +1,163 @@
+package server
+
+import (
+	"net/http"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"img-ops/config"
+	"img-ops/imgconversion"
+	"img-ops/imgprocessing"
+	"img-ops/jobs"
+)
+
+//parte que responde ao orquestrador e descreve o que o servidor sabe fazer
+
+//definida no build com -ldflags "-X img-ops/server.Version=..."
+
+var Version = "dev"
+
+//vira 1 quando o servidor recebe o sinal para desligar
+
+var shuttingDown int32
+
+func handleHealth(context *gin.Context) {
+	context.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+type memoryUsage struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit"`
+}
+
+type readiness struct {
+	Status string      `json:"status"`
+	Jobs   jobs.Stats  `json:"jobs"`
+	Memory memoryUsage `json:"memory"`
+}
+
+func newReadinessHandler(jobManager *jobs.Manager, budget *memoryBudget) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		used, limit := budget.usage()
+
+		response := readiness{
+			Status: "ready",
+			Jobs:   jobManager.Stats(),
+			Memory: memoryUsage{Used: used, Limit: limit},
+		}
+
+		if response.Jobs.Saturated() || (limit > 0 && used >= limit) {
+			response.Status = "saturated"
+		}
+
+		if atomic.LoadInt32(&shuttingDown) == 1 {
+			response.Status = "shutting down"
+		}
+
+		if response.Status != "ready" {
+			context.JSON(http.StatusServiceUnavailable, response)
+			return
+		}
+
+		context.JSON(http.StatusOK, response)
+	}
+}
+
+type endpointInfo struct {
+	Name   string `json:"name"`
+	Method string `json:"method"`
+	Route  string `json:"route"`
+}
+
+type formatInfo struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+}
+
+type operationInfo struct {
+	*imgprocessing.Operation
+	Route string `json:"route"`
+}
+
+type limitsInfo struct {
+	MaxBodySize    int64  `json:"maxBodySize"`
+	MaxWidth       int    `json:"maxWidth"`
+	MaxHeight      int    `json:"maxHeight"`
+	MaxPixels      int64  `json:"maxPixels"`
+	MemoryBudget   int64  `json:"memoryBudget"`
+	RequestTimeout string `json:"requestTimeout"`
+	JobTTL         string `json:"jobTTL"`
+	SessionHistory int    `json:"sessionHistory"`
+
+	RateLimit         float64 `json:"rateLimit"`
+	RateBurst         int     `json:"rateBurst"`
+	ClientConcurrency int     `json:"clientConcurrency"`
+}
+
+type versionInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+}
+
+type capabilities struct {
+	Version    versionInfo     `json:"version"`
+	Formats    []formatInfo    `json:"formats"`
+	AlphaModes []string        `json:"alphaModes"`
+	Operations []operationInfo `json:"operations"`
+	Endpoints  []endpointInfo  `json:"endpoints"`
+	Limits     limitsInfo      `json:"limits"`
+	Auth       bool            `json:"authRequired"`
+}
+
+//os formatos servem tanto para entrada quanto para saída, só as operações habilitadas aparecem
+
+func newCapabilitiesHandler(cfg config.Config, endpoints *[]endpointInfo) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		response := capabilities{
+			Version:    versionInfo{Version: Version, GoVersion: runtime.Version()},
+			Formats:    []formatInfo{},
+			AlphaModes: []string{"preserve", "flatten", "channel"},
+			Operations: []operationInfo{},
+			Endpoints:  *endpoints,
+			Limits: limitsInfo{
+				MaxBodySize:    cfg.MaxBodySize,
+				MaxWidth:       cfg.MaxWidth,
+				MaxHeight:      cfg.MaxHeight,
+				MaxPixels:      cfg.MaxPixels,
+				MemoryBudget:   cfg.MemoryBudget,
+				RequestTimeout: cfg.RequestTimeout.String(),
+				JobTTL:         cfg.JobTTL.String(),
+				SessionHistory: cfg.SessionHistory,
+
+				RateLimit:         cfg.RateLimit,
+				RateBurst:         cfg.RateBurst,
+				ClientConcurrency: cfg.ClientConcurrency,
+			},
+			Auth: cfg.AuthEnabled(),
+		}
+
+		for _, format := range imgconversion.Formats() {
+			response.Formats = append(response.Formats, formatInfo{Name: format, ContentType: imgconversion.ContentTypeOf(format)})
+		}
+
+		for _, op := range imgprocessing.Operations() {
+			if !cfg.EndpointEnabled(op.Name) {
+				continue
+			}
+
+			info := operationInfo{Operation: op, Route: operationRoute(op)}
+
+			if op.Params == nil {
+				opCopy := *op
+				opCopy.Params = []imgprocessing.Param{}
+				info.Operation = &opCopy
+			}
+
+			response.Operations = append(response.Operations, info)
+		}
+
+		context.JSON(http.StatusOK, response)
+	}
+}
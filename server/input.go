@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"img-ops/imgerrors"
+)
+
+//parte que aceita as imagens em multipart, no corpo cru ou em base64 dentro de um JSON
+
+const (
+	bodyMultipart = "multipart"
+	bodyJSON      = "json"
+	bodyRaw       = "raw"
+	bodyForm      = "form"
+)
+
+func bodyKind(context *gin.Context) string {
+	mediaType, _, _ := mime.ParseMediaType(context.GetHeader("Content-Type"))
+
+	switch {
+	case mediaType == "multipart/form-data":
+		return bodyMultipart
+	case mediaType == "application/json":
+		return bodyJSON
+	case strings.HasPrefix(mediaType, "image/") || mediaType == "application/octet-stream" || mediaType == "application/zip":
+		return bodyRaw
+	}
+
+	return bodyForm
+}
+
+//o corpo cru é sempre a primeira imagem, a segunda só pode ir em multipart ou JSON
+
+var rawBodyNames = map[string]bool{"img": true, "img1": true}
+
+const jsonBodyKey = "jsonBody"
+
+type jsonBody struct {
+	fields map[string]json.RawMessage
+	err    error
+}
+
+//o JSON é lido uma vez só e guardado no contexto
+
+func getJSONBody(context *gin.Context) (map[string]json.RawMessage, error) {
+	if value, exists := context.Get(jsonBodyKey); exists {
+		body := value.(*jsonBody)
+		return body.fields, body.err
+	}
+
+	body := &jsonBody{}
+
+	err := json.NewDecoder(context.Request.Body).Decode(&body.fields)
+	if err != nil {
+		body.fields = nil
+		body.err = err
+
+		if !strings.Contains(err.Error(), "http: request body too large") {
+			body.err = &imgerrors.Error{Code: imgerrors.CodeInvalidParameter, Message: "request body is not a valid JSON object: " + err.Error(), Param: "body", Err: err}
+		}
+	}
+
+	context.Set(jsonBodyKey, body)
+
+	return body.fields, body.err
+}
+
+//campos de texto, como op e steps, vêm do formulário, do JSON ou da query quando o corpo é a imagem
+
+func formValue(context *gin.Context, name string) (string, error) {
+	switch bodyKind(context) {
+	case bodyJSON:
+		fields, err := getJSONBody(context)
+		if err != nil {
+			return "", err
+		}
+
+		raw, exists := fields[name]
+		if !exists || string(raw) == "null" {
+			return "", nil
+		}
+
+		//objetos e listas ficam como o JSON original, assim params e steps podem ir sem virar string
+		var value string
+		if json.Unmarshal(raw, &value) != nil {
+			return string(raw), nil
+		}
+
+		return value, nil
+	case bodyRaw:
+		return context.Query(name), nil
+	}
+
+	return context.PostForm(name), nil
+}
+
+//aceita base64 puro ou uma data URI como as de um canvas no navegador
+
+func base64Reader(name string, value string) (io.Reader, error) {
+	if strings.HasPrefix(value, "data:") {
+		header, data, found := strings.Cut(value, ",")
+		if !found || !strings.HasSuffix(header, ";base64") {
+			return nil, imgerrors.InvalidParam(name, name+" must be a base64 data URI")
+		}
+
+		value = data
+	}
+
+	return base64.NewDecoder(base64.StdEncoding, strings.NewReader(value)), nil
+}
+
+func jsonUpload(context *gin.Context, name string) (io.Reader, error) {
+	fields, err := getJSONBody(context)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, exists := fields[name]
+	if !exists || string(raw) == "null" {
+		return nil, uploadError(name, http.ErrMissingFile)
+	}
+
+	var value string
+	if json.Unmarshal(raw, &value) != nil {
+		return nil, imgerrors.InvalidParam(name, name+" must be a base64 string or a data URI")
+	}
+
+	return base64Reader(name, value)
+}
+
+//devolve a imagem enviada com esse nome, seja qual for o formato do corpo
+
+func openUpload(context *gin.Context, name string) (io.Reader, error) {
+	switch bodyKind(context) {
+	case bodyJSON:
+		return jsonUpload(context, name)
+	case bodyRaw:
+		if !rawBodyNames[name] {
+			return nil, uploadError(name, http.ErrMissingFile)
+		}
+
+		return context.Request.Body, nil
+	}
+
+	multipartFile, _, err := context.Request.FormFile(name)
+	if err != nil {
+		return nil, uploadError(name, err)
+	}
+
+	return multipartFile, nil
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"img-ops/imgconversion"
+	"img-ops/imgdata"
+	"img-ops/imgerrors"
+	"img-ops/imgprocessing"
+	"img-ops/jobs"
+)
+
+//parte que recebe operações demoradas e devolve o resultado depois
+
+//op pode ser qualquer operação registrada ou "pipeline", que usa o campo steps
+
+func getOperationFromForm(context *gin.Context) (twoImageOperation, bool, error) {
+	cfg := getConfig(context)
+
+	opName, err := formValue(context, "op")
+	if err != nil {
+		return nil, false, err
+	}
+
+	if opName == "pipeline" {
+		stepsJSON, err := formValue(context, "steps")
+		if err != nil {
+			return nil, false, err
+		}
+
+		var steps []pipelineStep
+
+		err = json.Unmarshal([]byte(stepsJSON), &steps)
+		if err != nil {
+			return nil, false, errInvalidSteps
+		}
+
+		return buildPipeline(steps, cfg)
+	}
+
+	op, exists := imgprocessing.LookupOperation(opName)
+	if !exists || !cfg.EndpointEnabled(opName) {
+		return nil, false, imgerrors.InvalidParam("op", "unknown operation "+opName)
+	}
+
+	paramsStr, err := formValue(context, "params")
+	if err != nil {
+		return nil, false, err
+	}
+
+	values := map[string]float64{}
+
+	if paramsStr != "" {
+		err := json.Unmarshal([]byte(paramsStr), &values)
+		if err != nil {
+			return nil, false, imgerrors.InvalidParam("params", "params must be a JSON object of numbers")
+		}
+	}
+
+	params, err := op.ResolveParams(values)
+	if err != nil {
+		return nil, false, err
+	}
+
+	operation := func(ctx stdcontext.Context, img1 *imgdata.Image, img2 *imgdata.Image) (*imgdata.Image, error) {
+		return op.Apply(ctx, img1, img2, params)
+	}
+
+	return operation, op.Arity == 2, nil
+}
+
+func handleCreateJob(manager *jobs.Manager) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		operation, needsSecondImage, err := getOperationFromForm(context)
+		if err != nil {
+			sendError(context, err)
+			return
+		}
+
+		//a memória das imagens continua reservada até o job terminar, não até a requisição terminar, e a do resultado até o job expirar
+		jobReservation := &reservation{budget: newMemoryBudget(0, 0)}
+
+		if requestReservation, exists := getReservation(context); exists {
+			jobReservation.budget = requestReservation.budget
+			context.Set(reservationKey, jobReservation)
+		}
+
+		animation, err := loadAnimationFromParams(context, "img")
+		if err != nil {
+			jobReservation.releaseAll()
+			sendError(context, err)
+			return
+		}
+
+		secondAnimation := imgconversion.NewStaticAnimation(nil)
+
+		if needsSecondImage {
+			secondAnimation, err = loadAnimationFromParams(context, "img2")
+			if err != nil {
+				jobReservation.releaseAll()
+				sendError(context, err)
+				return
+			}
+		}
+
+		options, err := getAnimationEncodeOptions(context, animation.IsAnimated() || secondAnimation.IsAnimated())
+		if err != nil {
+			jobReservation.releaseAll()
+			sendError(context, err)
+			return
+		}
+
+		//o nome já foi lido e validado por getOperationFromForm
+		opName, _ := formValue(context, "op")
+
+		job, err := manager.Submit(opName, func(ctx stdcontext.Context, progress func(percent int)) (*jobs.Result, error) {
+			//os 100% só são marcados depois da codificação
+			ctx = imgprocessing.WithProgress(ctx, func(done int, total int) {
+				progress(done * 99 / total)
+			})
+
+			result, err := applyToFramePairs(ctx, animation, secondAnimation, operation)
+			if err != nil {
+				jobReservation.releaseAll()
+				return nil, err
+			}
+
+			data, err := encodeAnimation(result, options)
+			if err != nil {
+				jobReservation.releaseAll()
+				return nil, err
+			}
+
+			//a estimativa das imagens já cobre o resultado codificado, então só o tamanho dele continua reservado
+			jobReservation.shrink(int64(len(data)))
+
+			return &jobs.Result{ContentType: imgconversion.ContentTypeOf(options.Format), Data: data, Release: jobReservation.releaseAll}, nil
+		})
+		if err != nil {
+			jobReservation.releaseAll()
+
+			if errors.Is(err, jobs.ErrQueueFull) {
+				context.Header("Retry-After", "5")
+			}
+
+			sendError(context, err)
+			return
+		}
+
+		context.Header("Location", "/jobs/"+job.ID)
+		context.JSON(http.StatusAccepted, job)
+	}
+}
+
+func getJobFromParams(context *gin.Context, manager *jobs.Manager) (*jobs.Job, bool) {
+	job, err := manager.Get(context.Param("id"))
+	if err != nil {
+		sendError(context, err)
+		return nil, false
+	}
+
+	return job, true
+}
+
+func handleGetJob(manager *jobs.Manager) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		job, found := getJobFromParams(context, manager)
+		if !found {
+			return
+		}
+
+		context.JSON(http.StatusOK, job)
+	}
+}
+
+func handleGetJobResult(manager *jobs.Manager) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		asBase64, err := wantsBase64(context)
+		if err != nil {
+			sendError(context, err)
+			return
+		}
+
+		job, found := getJobFromParams(context, manager)
+		if !found {
+			return
+		}
+
+		if job.Status == jobs.StatusFailed {
+			sendError(context, imgerrors.New(imgerrors.CodeConflict, "job failed: "+job.Error))
+			return
+		}
+
+		if job.Status != jobs.StatusDone {
+			sendError(context, imgerrors.New(imgerrors.CodeConflict, "job is "+string(job.Status)+", try again later"))
+			return
+		}
+
+		result, err := manager.Result(job.ID)
+		if err != nil {
+			sendError(context, err)
+			return
+		}
+
+		err = sendResult(context, result.ContentType, asBase64, func(writer io.Writer) error {
+			_, err := writer.Write(result.Data)
+			return err
+		})
+		if err != nil {
+			sendStreamError(context, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"img-ops/config"
+)
+
+//parte que escreve os logs, uma linha JSON por mensagem, filtrada pelo nível configurado
+
+var logLevel = 1
+
+var logMutex sync.Mutex
+
+type logFields map[string]interface{}
+
+func levelIndex(level string) int {
+	for i, name := range config.LogLevels {
+		if name == level {
+			return i
+		}
+	}
+
+	return 0
+}
+
+func setLogLevel(level string) {
+	logLevel = levelIndex(level)
+}
+
+func logEnabled(level string) bool {
+	return levelIndex(level) >= logLevel
+}
+
+func logWith(level string, fields logFields, message string) {
+	if !logEnabled(level) {
+		return
+	}
+
+	entry := logFields{}
+
+	for key, value := range fields {
+		entry[key] = value
+	}
+
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = message
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(logFields{"level": "error", "msg": "could not encode log entry: " + err.Error()})
+	}
+
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	os.Stdout.Write(append(line, '\n'))
+}
+
+func logf(level string, format string, args ...interface{}) {
+	logWith(level, nil, fmt.Sprintf(format, args...))
+}
+
+//substitui o gin.Logger, cada requisição vira uma linha com o ID, a rota e quanto tempo levou
+
+func requestLogMiddleware(context *gin.Context) {
+	start := time.Now()
+
+	context.Next()
+
+	//Size é -1 quando nada foi escrito
+	bytesOut := context.Writer.Size()
+	if bytesOut < 0 {
+		bytesOut = 0
+	}
+
+	fields := logFields{
+		"requestId":  getRequestID(context),
+		"method":     context.Request.Method,
+		"path":       context.Request.URL.Path,
+		"endpoint":   getEndpoint(context),
+		"status":     context.Writer.Status(),
+		"durationMs": float64(time.Since(start).Microseconds()) / 1000,
+		"bytesIn":    context.Request.ContentLength,
+		"bytesOut":   bytesOut,
+		"clientIp":   context.ClientIP(),
+		"client":     getClient(context),
+	}
+
+	if code, exists := context.Get(errorCodeKey); exists {
+		fields["errorCode"] = code
+	}
+
+	logWith("info", fields, "request finished")
+}
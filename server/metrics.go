@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//parte que conta requisições e tempos, exposta em /metrics no formato de texto do Prometheus
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+}
+
+type metricVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mutex   sync.Mutex
+	series  map[string]*metricSeries
+}
+
+//sem buckets é um contador, com buckets é um histograma
+
+func newMetricVec(name string, help string, buckets []float64, labels ...string) *metricVec {
+	return &metricVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*metricSeries{},
+	}
+}
+
+func (vec *metricVec) getSeries(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\x00")
+
+	series, exists := vec.series[key]
+	if !exists {
+		series = &metricSeries{labelValues: labelValues, counts: make([]uint64, len(vec.buckets))}
+		vec.series[key] = series
+	}
+
+	return series
+}
+
+func (vec *metricVec) add(value float64, labelValues ...string) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	vec.getSeries(labelValues).value += value
+}
+
+func (vec *metricVec) observe(value float64, labelValues ...string) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	series := vec.getSeries(labelValues)
+
+	series.value += value
+	series.count++
+
+	for i, bucket := range vec.buckets {
+		if value <= bucket {
+			series.counts[i]++
+		}
+	}
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	pairs := []string{}
+
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabelValue(values[i])+`"`)
+	}
+
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func (vec *metricVec) write(buf *bytes.Buffer) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	kind := "counter"
+	if vec.buckets != nil {
+		kind = "histogram"
+	}
+
+	buf.WriteString("# HELP " + vec.name + " " + vec.help + "\n")
+	buf.WriteString("# TYPE " + vec.name + " " + kind + "\n")
+
+	keys := []string{}
+	for key := range vec.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := vec.series[key]
+
+		if vec.buckets == nil {
+			buf.WriteString(vec.name + formatLabels(vec.labels, series.labelValues, "", "") + " " + formatMetricValue(series.value) + "\n")
+			continue
+		}
+
+		for i, bucket := range vec.buckets {
+			buf.WriteString(vec.name + "_bucket" + formatLabels(vec.labels, series.labelValues, "le", formatMetricValue(bucket)) + " " + strconv.FormatUint(series.counts[i], 10) + "\n")
+		}
+
+		buf.WriteString(vec.name + "_bucket" + formatLabels(vec.labels, series.labelValues, "le", "+Inf") + " " + strconv.FormatUint(series.count, 10) + "\n")
+		buf.WriteString(vec.name + "_sum" + formatLabels(vec.labels, series.labelValues, "", "") + " " + formatMetricValue(series.value) + "\n")
+		buf.WriteString(vec.name + "_count" + formatLabels(vec.labels, series.labelValues, "", "") + " " + strconv.FormatUint(series.count, 10) + "\n")
+	}
+}
+
+//métricas do servidor
+
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+var sizeBuckets = []float64{1 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20, 256 << 20}
+
+var pixelBuckets = []float64{1e4, 1e5, 1e6, 4e6, 16e6, 64e6, 256e6}
+
+var (
+	requestsTotal   = newMetricVec("img_ops_requests_total", "Requests handled, by endpoint and HTTP status.", nil, "endpoint", "status")
+	errorsTotal     = newMetricVec("img_ops_errors_total", "Requests that failed, by endpoint and error code.", nil, "endpoint", "code")
+	requestDuration = newMetricVec("img_ops_request_duration_seconds", "Time spent handling a request, by endpoint.", durationBuckets, "endpoint")
+	requestSize     = newMetricVec("img_ops_request_size_bytes", "Size of the request body, by endpoint.", sizeBuckets, "endpoint")
+	inputPixels     = newMetricVec("img_ops_input_pixels", "Pixels of each uploaded image, all frames included, by endpoint.", pixelBuckets, "endpoint")
+	decodeDuration  = newMetricVec("img_ops_decode_duration_seconds", "Time spent decoding uploaded images, by format.", durationBuckets, "format")
+	encodeDuration  = newMetricVec("img_ops_encode_duration_seconds", "Time spent encoding results, by format.", durationBuckets, "format")
+	cacheRequests   = newMetricVec("img_ops_cache_requests_total", "Result cache lookups, by result: hit_memory, hit_disk, miss or not_modified.", nil, "result")
+)
+
+var allMetrics = []*metricVec{requestsTotal, errorsTotal, requestDuration, requestSize, inputPixels, decodeDuration, encodeDuration, cacheRequests}
+
+func metricsMiddleware(context *gin.Context) {
+	start := time.Now()
+
+	context.Next()
+
+	endpoint := getEndpoint(context)
+
+	requestsTotal.add(1, endpoint, strconv.Itoa(context.Writer.Status()))
+	requestDuration.observe(time.Since(start).Seconds(), endpoint)
+
+	if context.Request.ContentLength > 0 {
+		requestSize.observe(float64(context.Request.ContentLength), endpoint)
+	}
+
+	if code, exists := context.Get(errorCodeKey); exists {
+		errorsTotal.add(1, endpoint, code.(string))
+	}
+}
+
+func handleMetrics(context *gin.Context) {
+	buf := new(bytes.Buffer)
+
+	for _, vec := range allMetrics {
+		vec.write(buf)
+	}
+
+	context.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
+
+//o nome do endpoint é o mesmo usado na configuração, requisições sem rota ficam como "none"
+
+const endpointKey = "endpoint"
+
+func withEndpoint(name string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		context.Set(endpointKey, name)
+		handler(context)
+	}
+}
+
+func getEndpoint(context *gin.Context) string {
+	if endpoint := context.GetString(endpointKey); endpoint != "" {
+		return endpoint
+	}
+
+	return "none"
+}
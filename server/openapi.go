@@ -0,0 +1,475 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"img-ops/imgconversion"
+	"img-ops/imgprocessing"
+)
+
+//parte que monta o documento OpenAPI a partir das rotas registradas no gin
+
+func queryParam(name string, description string, schema gin.H) gin.H {
+	param := gin.H{"name": name, "in": "query", "schema": schema}
+
+	if description != "" {
+		param["description"] = description
+	}
+
+	return param
+}
+
+func pathParam(name string, description string, schema gin.H) gin.H {
+	param := queryParam(name, description, schema)
+
+	param["in"] = "path"
+	param["required"] = true
+
+	return param
+}
+
+//parâmetros de query lidos por todo endpoint que recebe e devolve imagens
+
+var imageQueryParams = []gin.H{
+	queryParam("format", "Output format, takes precedence over the Accept header.", gin.H{"type": "string", "enum": imgconversion.Formats()}),
+	queryParam("quality", "JPEG quality.", gin.H{"type": "integer"}),
+	queryParam("colors", "Palette size of GIF output.", gin.H{"type": "integer"}),
+	queryParam("dither", "Whether GIF output is dithered.", gin.H{"type": "boolean"}),
+	queryParam("ascii", "Whether netpbm output is written as text.", gin.H{"type": "boolean"}),
+	queryParam("compression", "Compression of TIFF output.", gin.H{"type": "string"}),
+	queryParam("alpha", "How the alpha channel of the input is handled.", gin.H{"type": "string", "enum": []string{"preserve", "flatten", "channel"}}),
+	queryParam("background", "Hex color the image is flattened onto when alpha is flatten.", gin.H{"type": "string", "default": "ffffff"}),
+	queryParam("orient", "Whether the EXIF orientation of the input is applied.", gin.H{"type": "boolean", "default": true}),
+}
+
+//resultados de operações são identificados pelo ETag, que não muda enquanto as entradas e opções forem as mesmas
+
+var base64Param = queryParam("base64", "Whether the result is returned as a Base64Result JSON object instead of binary.", gin.H{"type": "boolean", "default": false})
+
+var cachedResultParams = append([]gin.H{{
+	"name":        "If-None-Match",
+	"in":          "header",
+	"description": "ETag of a result the client already has. These operations are POSTs, so a match is answered with 412 and code precondition_failed instead of 304, and the client keeps using the result it stored with that ETag. The images are still uploaded and decoded, because the ETag is computed from the decoded pixels.",
+	"schema":      gin.H{"type": "string"},
+}, base64Param}, imageQueryParams...)
+
+func cachedImageResponses() gin.H {
+	ok := imageResponse("The resulting image.")
+	ok["headers"] = gin.H{
+		"ETag":    gin.H{"description": "Identifies the inputs, operation and output options. Send it back in If-None-Match to skip downloading a result the client already has.", "schema": gin.H{"type": "string"}},
+		"X-Cache": gin.H{"description": "HIT when the result came from the cache, MISS when it was computed.", "schema": gin.H{"type": "string"}},
+	}
+
+	return withErrorResponses(gin.H{"200": ok})
+}
+
+var idParam = pathParam("id", "ID of the job or request.", gin.H{"type": "string"})
+
+var binarySchema = gin.H{"type": "string", "format": "binary"}
+
+var base64Schema = gin.H{"type": "string", "description": "Base64 of the file, or a data URI with base64 encoding."}
+
+//no JSON os arquivos vão em base64, e o corpo cru é a primeira imagem ou o ZIP do lote
+
+func uploadBody(properties gin.H, required ...string) gin.H {
+	multipartSchema := gin.H{"type": "object", "properties": properties}
+	jsonProperties := gin.H{}
+
+	for name, property := range properties {
+		jsonProperties[name] = property
+
+		if property.(gin.H)["format"] == "binary" {
+			jsonProperties[name] = base64Schema
+		}
+
+		if items, isArray := property.(gin.H)["items"]; isArray && items.(gin.H)["format"] == "binary" {
+			jsonProperties[name] = gin.H{"oneOf": []gin.H{base64Schema, {"type": "array", "items": base64Schema}}}
+		}
+	}
+
+	jsonSchema := gin.H{"type": "object", "properties": jsonProperties}
+
+	if len(required) > 0 {
+		multipartSchema["required"] = required
+		jsonSchema["required"] = required
+	}
+
+	content := gin.H{
+		"multipart/form-data": gin.H{"schema": multipartSchema},
+		"application/json":    gin.H{"schema": jsonSchema},
+	}
+
+	for name := range rawBodyNames {
+		if _, exists := properties[name]; exists {
+			content["image/*"] = gin.H{"schema": binarySchema}
+		}
+	}
+
+	if _, exists := properties["archive"]; exists {
+		content["application/zip"] = gin.H{"schema": binarySchema}
+	}
+
+	return gin.H{
+		"required":    true,
+		"description": "A raw image body is the first image, other fields then go in the query. A raw ZIP body is the archive.",
+		"content":     content,
+	}
+}
+
+func contentResponse(description string, contentType string, schema gin.H) gin.H {
+	return gin.H{"description": description, "content": gin.H{contentType: gin.H{"schema": schema}}}
+}
+
+//todo erro usa o formato de ErrorResponse, os códigos de cada status vêm de statusOfCode
+
+func withErrorResponses(responses gin.H) gin.H {
+	codesOfStatus := map[int][]string{}
+
+	for code, status := range statusOfCode {
+		codesOfStatus[status] = append(codesOfStatus[status], string(code))
+	}
+
+	for status, codes := range codesOfStatus {
+		sort.Strings(codes)
+
+		responses[strconv.Itoa(status)] = contentResponse("Error with code "+strings.Join(codes, " or ")+".", "application/json", gin.H{"$ref": "#/components/schemas/ErrorResponse"})
+	}
+
+	return responses
+}
+
+func imageResponse(description string) gin.H {
+	content := gin.H{}
+
+	for _, format := range imgconversion.Formats() {
+		content[imgconversion.ContentTypeOf(format)] = gin.H{"schema": binarySchema}
+	}
+
+	content["application/json"] = gin.H{"schema": gin.H{"$ref": "#/components/schemas/Base64Result"}}
+
+	return gin.H{"description": description, "content": content}
+}
+
+func paramSchema(param imgprocessing.Param) gin.H {
+	schema := gin.H{"type": "number"}
+
+	if param.Type == imgprocessing.ParamInt {
+		schema["type"] = "integer"
+	}
+
+	if param.Min != nil {
+		schema["minimum"] = *param.Min
+	}
+
+	if param.Max != nil {
+		schema["maximum"] = *param.Max
+	}
+
+	if param.Default != nil {
+		schema["default"] = *param.Default
+	}
+
+	return schema
+}
+
+//operações do registro, os parâmetros obrigatórios vão no caminho como em operationRoute
+
+func registeredOperationDoc(op *imgprocessing.Operation) gin.H {
+	parameters := []gin.H{}
+
+	for _, param := range op.Params {
+		if param.Required() {
+			parameters = append(parameters, pathParam(param.Name, "", paramSchema(param)))
+		} else {
+			parameters = append(parameters, queryParam(param.Name, "", paramSchema(param)))
+		}
+	}
+
+	body := uploadBody(gin.H{"img": binarySchema}, "img")
+	if op.Arity == 2 {
+		body = uploadBody(gin.H{"img1": binarySchema, "img2": binarySchema}, "img1", "img2")
+	}
+
+	return gin.H{
+		"operationId": op.Name,
+		"tags":        []string{"operations"},
+		"summary":     "Apply " + op.Name + " to every frame of the uploaded image.",
+		"parameters":  append(parameters, cachedResultParams...),
+		"requestBody": body,
+		"responses":   cachedImageResponses(),
+	}
+}
+
+//rotas que não vêm do registro, a chave é o método e o caminho no formato do OpenAPI
+
+var endpointDocs = map[string]gin.H{
+	"POST /process-img/metadata": {
+		"operationId": "metadata",
+		"tags":        []string{"operations"},
+		"summary":     "Read the format, size and EXIF metadata of an image.",
+		"requestBody": uploadBody(gin.H{"img": binarySchema}, "img"),
+		"responses":   withErrorResponses(gin.H{"200": contentResponse("The image metadata.", "application/json", gin.H{"type": "object"})}),
+	},
+	"POST /process-img/pipeline": {
+		"operationId": "pipeline",
+		"tags":        []string{"operations"},
+		"summary":     "Apply a list of registered operations in order, img2 is used by the operations that take two images.",
+		"parameters":  cachedResultParams,
+		"requestBody": uploadBody(gin.H{"img": binarySchema, "img2": binarySchema, "steps": stepsSchema}, "img", "steps"),
+		"responses":   cachedImageResponses(),
+	},
+	"POST /process-img/batch": {
+		"operationId": "batch",
+		"tags":        []string{"operations"},
+		"summary":     "Apply an operation or a pipeline to every image of a ZIP file or of the img fields.",
+		"parameters":  append([]gin.H{base64Param}, imageQueryParams...),
+		"requestBody": uploadBody(gin.H{
+			"archive": binarySchema,
+			"img":     gin.H{"type": "array", "items": binarySchema},
+			"img2":    binarySchema,
+			"op":      gin.H{"type": "string", "description": "Name of a registered operation, or pipeline to use steps."},
+			"params":  gin.H{"type": "string", "description": "JSON object with the parameters of op, in a JSON body it can also be the object itself."},
+			"steps":   stepsSchema,
+		}, "op"),
+		"responses": withErrorResponses(gin.H{"200": gin.H{
+			"description": "ZIP with the results and a manifest.json telling which images failed and why.",
+			"content": gin.H{
+				"application/zip":  gin.H{"schema": binarySchema},
+				"application/json": gin.H{"schema": gin.H{"$ref": "#/components/schemas/Base64Result"}},
+			},
+		}}),
+	},
+	"GET /process-img/session": {
+		"operationId": "session",
+		"tags":        []string{"operations"},
+		"summary":     "Open a WebSocket editing session that keeps the image on the server, with previews, undo, redo and export.",
+		"description": "Client messages are JSON objects with a type: load (image as base64 or data URI, or a binary message; name img2 loads the second image of two-image operations), " +
+			"apply and preview (op and params, or steps as in the pipeline), undo, redo and export (format, quality). " +
+			"previewSize and previewFormat in any message change the following previews. " +
+			"Each message is answered with a SessionResponse of the same type and id, or of type error. A preview superseded by a newer one before it started is not answered.",
+		"parameters": imageQueryParams,
+		"responses": withErrorResponses(gin.H{
+			"101": gin.H{"description": "Switched to the WebSocket protocol, the messages are described above."},
+		}),
+	},
+	"POST /jobs": {
+		"operationId": "createJob",
+		"tags":        []string{"jobs"},
+		"summary":     "Run an operation or a pipeline in the background.",
+		"parameters":  imageQueryParams,
+		"requestBody": uploadBody(gin.H{
+			"img":    binarySchema,
+			"img2":   binarySchema,
+			"op":     gin.H{"type": "string", "description": "Name of a registered operation, or pipeline to use steps."},
+			"params": gin.H{"type": "string", "description": "JSON object with the parameters of op, in a JSON body it can also be the object itself."},
+			"steps":  stepsSchema,
+		}, "img", "op"),
+		"responses": withErrorResponses(gin.H{"202": contentResponse("The queued job, its URL is in the Location header.", "application/json", gin.H{"$ref": "#/components/schemas/Job"})}),
+	},
+	"GET /jobs/{id}": {
+		"operationId": "getJob",
+		"tags":        []string{"jobs"},
+		"parameters":  []gin.H{idParam},
+		"responses":   withErrorResponses(gin.H{"200": contentResponse("The job status.", "application/json", gin.H{"$ref": "#/components/schemas/Job"})}),
+	},
+	"GET /jobs/{id}/result": {
+		"operationId": "getJobResult",
+		"tags":        []string{"jobs"},
+		"parameters":  []gin.H{idParam, base64Param},
+		"responses":   withErrorResponses(gin.H{"200": imageResponse("The image produced by the job.")}),
+	},
+	"GET /jobs/{id}/events": {
+		"operationId": "getJobEvents",
+		"tags":        []string{"jobs"},
+		"parameters":  []gin.H{idParam},
+		"responses":   withErrorResponses(gin.H{"200": contentResponse("Job status events until the job finishes.", "text/event-stream", gin.H{"type": "string"})}),
+	},
+	"GET /requests/{id}/events": {
+		"operationId": "getRequestEvents",
+		"tags":        []string{"operations"},
+		"summary":     "Progress of a request in flight made by the same client, identified by the X-Request-ID header it was sent with. Only one request per client may use an ID at a time.",
+		"parameters":  []gin.H{idParam},
+		"responses":   withErrorResponses(gin.H{"200": contentResponse("Progress events until the request finishes.", "text/event-stream", gin.H{"type": "string"})}),
+	},
+	"GET /metrics": {
+		"operationId": "metrics",
+		"tags":        []string{"server"},
+		"responses":   gin.H{"200": contentResponse("Metrics in the Prometheus text format.", "text/plain", gin.H{"type": "string"})},
+	},
+	"GET /capabilities": {
+		"operationId": "capabilities",
+		"tags":        []string{"server"},
+		"responses":   gin.H{"200": contentResponse("Formats, operations, endpoints and limits of this server.", "application/json", gin.H{"type": "object"})},
+	},
+	"GET /openapi.json": {
+		"operationId": "openapi",
+		"tags":        []string{"server"},
+		"responses":   gin.H{"200": contentResponse("This document.", "application/json", gin.H{"type": "object"})},
+	},
+	"GET /healthz": {
+		"operationId": "health",
+		"tags":        []string{"server"},
+		"responses":   gin.H{"200": contentResponse("The server is running.", "application/json", gin.H{"type": "object"})},
+	},
+	"GET /readyz": {
+		"operationId": "readiness",
+		"tags":        []string{"server"},
+		"responses": gin.H{
+			"200": contentResponse("The server accepts new work.", "application/json", gin.H{"type": "object"}),
+			"503": contentResponse("The job queue or the memory budget is full, or the server is shutting down.", "application/json", gin.H{"type": "object"}),
+		},
+	},
+}
+
+var stepsSchema = gin.H{"type": "string", "description": `JSON list of {"op", "params"} objects, in a JSON body it can also be the list itself.`}
+
+func errorCodes() []string {
+	codes := []string{}
+
+	for code := range statusOfCode {
+		codes = append(codes, string(code))
+	}
+	sort.Strings(codes)
+
+	return codes
+}
+
+var openAPISchemas = gin.H{
+	"ErrorResponse": gin.H{
+		"type":     "object",
+		"required": []string{"code", "message"},
+		"properties": gin.H{
+			"code":      gin.H{"type": "string", "enum": errorCodes()},
+			"message":   gin.H{"type": "string"},
+			"param":     gin.H{"type": "string"},
+			"requestId": gin.H{"type": "string"},
+		},
+	},
+	"Base64Result": gin.H{
+		"type":     "object",
+		"required": []string{"contentType", "data"},
+		"properties": gin.H{
+			"contentType": gin.H{"type": "string"},
+			"data":        gin.H{"type": "string", "format": "byte"},
+		},
+	},
+	"SessionResponse": gin.H{
+		"type":     "object",
+		"required": []string{"type"},
+		"properties": gin.H{
+			"id":          gin.H{"type": "string"},
+			"type":        gin.H{"type": "string", "enum": []string{"load", "apply", "preview", "undo", "redo", "export", "error"}},
+			"width":       gin.H{"type": "integer"},
+			"height":      gin.H{"type": "integer"},
+			"frames":      gin.H{"type": "integer"},
+			"history":     gin.H{"type": "array", "items": gin.H{"type": "string"}},
+			"position":    gin.H{"type": "integer"},
+			"canUndo":     gin.H{"type": "boolean"},
+			"canRedo":     gin.H{"type": "boolean"},
+			"contentType": gin.H{"type": "string"},
+			"data":        gin.H{"type": "string", "format": "byte"},
+			"error":       gin.H{"$ref": "#/components/schemas/ErrorResponse"},
+		},
+	},
+	"Job": gin.H{
+		"type": "object",
+		"properties": gin.H{
+			"id":         gin.H{"type": "string"},
+			"operation":  gin.H{"type": "string"},
+			"status":     gin.H{"type": "string", "enum": []string{"queued", "running", "done", "failed"}},
+			"progress":   gin.H{"type": "integer", "minimum": 0, "maximum": 100},
+			"error":      gin.H{"type": "string"},
+			"createdAt":  gin.H{"type": "string", "format": "date-time"},
+			"startedAt":  gin.H{"type": "string", "format": "date-time"},
+			"finishedAt": gin.H{"type": "string", "format": "date-time"},
+			"expiresAt":  gin.H{"type": "string", "format": "date-time"},
+		},
+	},
+}
+
+var securitySchemes = gin.H{
+	"apiKey":      gin.H{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+	"bearerToken": gin.H{"type": "http", "scheme": "bearer", "description": "Token of the form client.expires.signature, where signature is the hex HMAC-SHA256 of client.expires with the server secret."},
+}
+
+//:id do gin vira {id}
+
+func openAPIPath(route string) string {
+	parts := strings.Split(route, "/")
+
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+//toda rota registrada precisa estar documentada, senão o servidor não sobe
+
+func buildOpenAPI(routes gin.RoutesInfo, authEnabled bool) (gin.H, error) {
+	operations := map[string]*imgprocessing.Operation{}
+
+	for _, op := range imgprocessing.Operations() {
+		operations[operationRoute(op)] = op
+	}
+
+	paths := gin.H{}
+	undocumented := []string{}
+
+	for _, route := range routes {
+		path := openAPIPath(route.Path)
+
+		doc, exists := endpointDocs[route.Method+" "+path]
+
+		if op, isOperation := operations[route.Path]; isOperation && route.Method == http.MethodPost {
+			doc, exists = registeredOperationDoc(op), true
+		}
+
+		if !exists {
+			undocumented = append(undocumented, route.Method+" "+route.Path)
+			continue
+		}
+
+		if publicRoutes[route.Path] {
+			doc["security"] = []gin.H{}
+		}
+
+		if _, exists := paths[path]; !exists {
+			paths[path] = gin.H{}
+		}
+
+		paths[path].(gin.H)[strings.ToLower(route.Method)] = doc
+	}
+
+	if len(undocumented) > 0 {
+		return nil, errors.New("routes missing from the OpenAPI document: " + strings.Join(undocumented, ", "))
+	}
+
+	spec := gin.H{
+		"openapi": "3.0.3",
+		"info": gin.H{
+			"title":       "img-ops",
+			"description": "Image processing over HTTP. Every error is an ErrorResponse whose code decides the HTTP status.",
+			"version":     Version,
+		},
+		"paths": paths,
+		"components": gin.H{
+			"schemas":         openAPISchemas,
+			"securitySchemes": securitySchemes,
+		},
+	}
+
+	if authEnabled {
+		spec["security"] = []gin.H{{"apiKey": []string{}}, {"bearerToken": []string{}}}
+	}
+
+	return spec, nil
+}
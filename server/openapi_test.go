@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"img-ops/config"
+)
+
+//toda rota registrada precisa aparecer no /openapi.json com o mesmo método
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	router, closeRouter, err := newRouter(config.Default())
+	if err != nil {
+		t.Fatalf("building the router: %v", err)
+	}
+	defer closeRouter()
+
+	request := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	recorder := httptest.NewRecorder()
+
+	router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+
+	err = json.Unmarshal(recorder.Body.Bytes(), &spec)
+	if err != nil {
+		t.Fatalf("decoding the OpenAPI document: %v", err)
+	}
+
+	routes := router.Routes()
+
+	if len(routes) == 0 {
+		t.Fatal("router has no routes")
+	}
+
+	for _, route := range routes {
+		if _, exists := spec.Paths[openAPIPath(route.Path)][strings.ToLower(route.Method)]; !exists {
+			t.Errorf("%s %s is not in the OpenAPI document", route.Method, route.Path)
+		}
+	}
+}
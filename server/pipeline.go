@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"img-ops/config"
+	"img-ops/imgconversion"
+	"img-ops/imgdata"
+	"img-ops/imgerrors"
+	"img-ops/imgprocessing"
+)
+
+//parte que executa várias operações em sequência sobre a mesma imagem
+
+type pipelineStep struct {
+	Op     string             `json:"op"`
+	Params map[string]float64 `json:"params"`
+}
+
+var errInvalidSteps = imgerrors.InvalidParam("steps", "steps must be a JSON list of {\"op\", \"params\"} objects")
+
+//o erro do passo mantém o código e o parâmetro, só ganha o número do passo na mensagem
+
+func stepError(i int, err error) error {
+	stepErr := &imgerrors.Error{Code: imgerrors.CodeInvalidParameter, Message: err.Error(), Err: err}
+
+	if typedErr, ok := imgerrors.As(err); ok {
+		stepErr.Code = typedErr.Code
+		stepErr.Param = typedErr.Param
+	}
+
+	stepErr.Message = "step " + strconv.Itoa(i) + ": " + stepErr.Message
+
+	return stepErr
+}
+
+func buildPipeline(steps []pipelineStep, cfg config.Config) (twoImageOperation, bool, error) {
+	if len(steps) == 0 {
+		return nil, false, imgerrors.InvalidParam("steps", "pipeline must have at least one step")
+	}
+
+	operations := []*imgprocessing.Operation{}
+	operationParams := []imgprocessing.Params{}
+	needsSecondImage := false
+
+	for i, step := range steps {
+		op, exists := imgprocessing.LookupOperation(step.Op)
+		if !exists || !cfg.EndpointEnabled(step.Op) {
+			return nil, false, imgerrors.InvalidParam("steps", "step "+strconv.Itoa(i)+": unknown operation "+step.Op)
+		}
+
+		params, err := op.ResolveParams(step.Params)
+		if err != nil {
+			return nil, false, stepError(i, err)
+		}
+
+		operations = append(operations, op)
+		operationParams = append(operationParams, params)
+		needsSecondImage = needsSecondImage || op.Arity == 2
+	}
+
+	pipeline := func(ctx context.Context, img *imgdata.Image, img2 *imgdata.Image) (*imgdata.Image, error) {
+		var err error
+
+		for i, op := range operations {
+			img, err = op.Apply(imgprocessing.ScaleProgress(ctx, i, len(operations)), img, img2, operationParams[i])
+			if err != nil {
+				return nil, err
+			}
+
+			imgprocessing.ReportProgress(ctx, i+1, len(operations))
+		}
+
+		return img, nil
+	}
+
+	return pipeline, needsSecondImage, nil
+}
+
+func handlePipeline(context *gin.Context) {
+	stepsJSON, err := formValue(context, "steps")
+	if err != nil {
+		sendError(context, err)
+		return
+	}
+
+	var steps []pipelineStep
+
+	err = json.Unmarshal([]byte(stepsJSON), &steps)
+	if err != nil {
+		sendError(context, errInvalidSteps)
+		return
+	}
+
+	pipeline, needsSecondImage, err := buildPipeline(steps, getConfig(context))
+	if err != nil {
+		sendError(context, err)
+		return
+	}
+
+	names := []string{"img"}
+
+	if needsSecondImage {
+		names = append(names, "img2")
+	}
+
+	normalizedSteps, err := json.Marshal(steps)
+	if err != nil {
+		sendError(context, err)
+		return
+	}
+
+	sendCachedAnimation(context, "pipeline "+string(normalizedSteps), names, func(inputs []*imgconversion.Animation) (*imgconversion.Animation, error) {
+		secondAnimation := imgconversion.NewStaticAnimation(nil)
+
+		if needsSecondImage {
+			secondAnimation = inputs[1]
+		}
+
+		return applyToFramePairs(context.Request.Context(), inputs[0], secondAnimation, pipeline)
+	})
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"img-ops/imgerrors"
+	"img-ops/imgprocessing"
+	"img-ops/jobs"
+)
+
+//parte que guarda o progresso das requisições em andamento e o envia por Server-Sent Events
+
+type progressTracker struct {
+	mutex    sync.Mutex
+	percent  int
+	finished bool
+	changed  chan struct{}
+}
+
+type progressEvent struct {
+	Percent  int  `json:"percent"`
+	Finished bool `json:"finished"`
+}
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{changed: make(chan struct{})}
+}
+
+func (tracker *progressTracker) notify() {
+	close(tracker.changed)
+	tracker.changed = make(chan struct{})
+}
+
+func (tracker *progressTracker) report(done int, total int) {
+	percent := done * 100 / total
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if percent == tracker.percent {
+		return
+	}
+
+	tracker.percent = percent
+	tracker.notify()
+}
+
+func (tracker *progressTracker) finish() {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.finished = true
+	tracker.notify()
+}
+
+func (tracker *progressTracker) snapshot() (progressEvent, chan struct{}) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	return progressEvent{Percent: tracker.percent, Finished: tracker.finished}, tracker.changed
+}
+
+//o ID vem do cliente, então cada cliente só enxerga as próprias requisições
+
+type progressKey struct {
+	client    string
+	requestID string
+}
+
+type progressRegistry struct {
+	mutex    sync.Mutex
+	trackers map[progressKey]*progressTracker
+}
+
+func newProgressRegistry() *progressRegistry {
+	return &progressRegistry{trackers: map[progressKey]*progressTracker{}}
+}
+
+func (registry *progressRegistry) get(key progressKey) (*progressTracker, bool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	tracker, exists := registry.trackers[key]
+
+	return tracker, exists
+}
+
+//um ID repetido trocaria o tracker de outra requisição em andamento, então é recusado
+
+func (registry *progressRegistry) add(key progressKey, tracker *progressTracker) bool {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if _, exists := registry.trackers[key]; exists {
+		return false
+	}
+
+	registry.trackers[key] = tracker
+
+	return true
+}
+
+func (registry *progressRegistry) remove(key progressKey) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	delete(registry.trackers, key)
+}
+
+//as operações da requisição avisam o progresso pelo context, sem saber do tracker
+
+func newProgressMiddleware(registry *progressRegistry) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		key := progressKey{client: getClient(context), requestID: getRequestID(context)}
+		tracker := newProgressTracker()
+
+		if !registry.add(key, tracker) {
+			sendError(context, imgerrors.New(imgerrors.CodeConflict, "a request with this X-Request-ID is already in progress"))
+			return
+		}
+
+		defer func() {
+			tracker.finish()
+			registry.remove(key)
+		}()
+
+		ctx := imgprocessing.WithProgress(context.Request.Context(), tracker.report)
+		context.Request = context.Request.WithContext(ctx)
+
+		context.Next()
+	}
+}
+
+func handleRequestEvents(registry *progressRegistry) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		tracker, exists := registry.get(progressKey{client: getClient(context), requestID: context.Param("id")})
+		if !exists {
+			sendError(context, imgerrors.New(imgerrors.CodeNotFound, "no request in progress with this ID"))
+			return
+		}
+
+		ctx := context.Request.Context()
+
+		context.Stream(func(w io.Writer) bool {
+			event, changed := tracker.snapshot()
+
+			if event.Finished {
+				context.SSEvent("done", event)
+				return false
+			}
+
+			context.SSEvent("progress", event)
+
+			select {
+			case <-changed:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}
+}
+
+//o progresso dos jobs fica no Store, que não avisa mudanças, então é consultado de tempos em tempos
+
+const jobEventsInterval = 500 * time.Millisecond
+
+func handleJobEvents(manager *jobs.Manager) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		job, found := getJobFromParams(context, manager)
+		if !found {
+			return
+		}
+
+		ctx := context.Request.Context()
+
+		ticker := time.NewTicker(jobEventsInterval)
+		defer ticker.Stop()
+
+		var last *jobs.Job
+
+		context.Stream(func(w io.Writer) bool {
+			if last == nil || last.Status != job.Status || last.Progress != job.Progress {
+				if job.Finished() {
+					context.SSEvent("done", job)
+					return false
+				}
+
+				context.SSEvent("progress", job)
+				last = job
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return false
+			}
+
+			newJob, err := manager.Get(job.ID)
+			if err != nil {
+				context.SSEvent("error", newErrorResponse(context, classifyError(context, err)))
+				return false
+			}
+
+			job = newJob
+
+			return true
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"img-ops/imgerrors"
+)
+
+//parte que limita quantas requisições cada cliente faz por segundo e quantas ficam em andamento ao mesmo tempo
+
+type tokenBucket struct {
+	tokens   float64
+	updated  time.Time
+	inFlight int
+}
+
+type clientLimiter struct {
+	mutex       sync.Mutex
+	rate        float64
+	burst       int
+	concurrency int
+	clients     map[string]*tokenBucket
+}
+
+func newClientLimiter(rate float64, burst int, concurrency int) *clientLimiter {
+	return &clientLimiter{
+		rate:        rate,
+		burst:       burst,
+		concurrency: concurrency,
+		clients:     map[string]*tokenBucket{},
+	}
+}
+
+func (limiter *clientLimiter) refill(bucket *tokenBucket, now time.Time) {
+	elapsed := now.Sub(bucket.updated).Seconds()
+
+	bucket.tokens = math.Min(float64(limiter.burst), bucket.tokens+elapsed*limiter.rate)
+	bucket.updated = now
+}
+
+//quando recusa, devolve quanto tempo o cliente deve esperar antes de tentar de novo
+
+func (limiter *clientLimiter) acquire(client string, now time.Time) (time.Duration, error) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	bucket, exists := limiter.clients[client]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(limiter.burst), updated: now}
+		limiter.clients[client] = bucket
+	}
+
+	if limiter.concurrency > 0 && bucket.inFlight >= limiter.concurrency {
+		return time.Second, imgerrors.New(imgerrors.CodeRateLimited, "more than "+strconv.Itoa(limiter.concurrency)+" requests in progress for this client")
+	}
+
+	if limiter.rate > 0 {
+		limiter.refill(bucket, now)
+
+		if bucket.tokens < 1 {
+			wait := time.Duration((1 - bucket.tokens) / limiter.rate * float64(time.Second))
+
+			return wait, imgerrors.New(imgerrors.CodeRateLimited, "rate limit of "+strconv.FormatFloat(limiter.rate, 'g', -1, 64)+" requests per second exceeded")
+		}
+
+		bucket.tokens--
+	}
+
+	bucket.inFlight++
+
+	return 0, nil
+}
+
+//clientes sem requisições em andamento e com o balde cheio são esquecidos, senão o mapa cresceria com cada IP
+
+func (limiter *clientLimiter) release(client string, now time.Time) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	bucket, exists := limiter.clients[client]
+	if !exists {
+		return
+	}
+
+	bucket.inFlight--
+
+	if limiter.rate > 0 {
+		limiter.refill(bucket, now)
+	}
+
+	if bucket.inFlight == 0 && (limiter.rate == 0 || bucket.tokens >= float64(limiter.burst)) {
+		delete(limiter.clients, client)
+	}
+}
+
+func newRateLimitMiddleware(limiter *clientLimiter) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if publicRoutes[context.FullPath()] {
+			context.Next()
+			return
+		}
+
+		client := getClient(context)
+
+		wait, err := limiter.acquire(client, time.Now())
+		if err != nil {
+			context.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			sendError(context, err)
+			return
+		}
+		defer limiter.release(client, time.Now())
+
+		context.Next()
+	}
+}
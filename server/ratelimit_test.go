@@ -0,0 +1,203 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"img-ops/config"
+	"img-ops/imgerrors"
+)
+
+//at é o tempo desde o início do teste, e clients é quantos clientes o limiter ainda guarda depois do passo
+
+type limiterStep struct {
+	op       string
+	client   string
+	at       time.Duration
+	wantWait time.Duration
+	wantErr  bool
+	clients  int
+}
+
+func TestClientLimiter(t *testing.T) {
+	cases := []struct {
+		name        string
+		rate        float64
+		burst       int
+		concurrency int
+		steps       []limiterStep
+	}{
+		{
+			name:  "burst is spent and tokens refill with time",
+			rate:  2,
+			burst: 2,
+			steps: []limiterStep{
+				{op: "acquire", client: "a", clients: 1},
+				{op: "acquire", client: "a", clients: 1},
+				{op: "acquire", client: "a", wantErr: true, wantWait: 500 * time.Millisecond, clients: 1},
+				{op: "acquire", client: "a", at: 250 * time.Millisecond, wantErr: true, wantWait: 250 * time.Millisecond, clients: 1},
+				{op: "acquire", client: "a", at: 500 * time.Millisecond, clients: 1},
+				{op: "acquire", client: "a", at: 500 * time.Millisecond, wantErr: true, wantWait: 500 * time.Millisecond, clients: 1},
+			},
+		},
+		{
+			name:  "tokens never exceed the burst",
+			rate:  10,
+			burst: 1,
+			steps: []limiterStep{
+				{op: "acquire", client: "a", clients: 1},
+				{op: "acquire", client: "a", at: time.Hour, clients: 1},
+				{op: "acquire", client: "a", at: time.Hour, wantErr: true, wantWait: 100 * time.Millisecond, clients: 1},
+			},
+		},
+		{
+			name:  "each client has its own bucket",
+			rate:  1,
+			burst: 1,
+			steps: []limiterStep{
+				{op: "acquire", client: "a", clients: 1},
+				{op: "acquire", client: "a", wantErr: true, wantWait: time.Second, clients: 1},
+				{op: "acquire", client: "b", clients: 2},
+			},
+		},
+		{
+			name:        "concurrency quota is freed on release",
+			burst:       1,
+			concurrency: 2,
+			steps: []limiterStep{
+				{op: "acquire", client: "a", clients: 1},
+				{op: "acquire", client: "a", clients: 1},
+				{op: "acquire", client: "a", wantErr: true, wantWait: time.Second, clients: 1},
+				{op: "acquire", client: "b", clients: 2},
+				{op: "release", client: "a", clients: 2},
+				{op: "acquire", client: "a", clients: 2},
+			},
+		},
+		{
+			name:        "refused requests do not take a concurrency slot",
+			rate:        1,
+			burst:       1,
+			concurrency: 1,
+			steps: []limiterStep{
+				{op: "acquire", client: "a", clients: 1},
+				{op: "acquire", client: "a", wantErr: true, wantWait: time.Second, clients: 1},
+				{op: "release", client: "a", at: time.Second, clients: 0},
+				{op: "acquire", client: "a", at: time.Second, clients: 1},
+			},
+		},
+		{
+			name:        "clients with nothing in flight are forgotten without a rate",
+			burst:       1,
+			concurrency: 1,
+			steps: []limiterStep{
+				{op: "acquire", client: "a", clients: 1},
+				{op: "release", client: "a", clients: 0},
+				{op: "release", client: "a", clients: 0},
+			},
+		},
+		{
+			name:  "clients are forgotten only once the bucket is full again",
+			rate:  1,
+			burst: 2,
+			steps: []limiterStep{
+				{op: "acquire", client: "a", clients: 1},
+				{op: "release", client: "a", at: 500 * time.Millisecond, clients: 1},
+				{op: "acquire", client: "a", at: 500 * time.Millisecond, clients: 1},
+				{op: "acquire", client: "b", at: 500 * time.Millisecond, clients: 2},
+				{op: "release", client: "a", at: 2 * time.Second, clients: 1},
+				{op: "release", client: "b", at: 2 * time.Second, clients: 0},
+			},
+		},
+	}
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			limiter := newClientLimiter(testCase.rate, testCase.burst, testCase.concurrency)
+
+			for i, step := range testCase.steps {
+				now := start.Add(step.at)
+
+				switch step.op {
+				case "acquire":
+					wait, err := limiter.acquire(step.client, now)
+
+					if step.wantErr {
+						typedErr, isTyped := imgerrors.As(err)
+						if !isTyped || typedErr.Code != imgerrors.CodeRateLimited {
+							t.Fatalf("step %d: got error %v, want rate_limited", i, err)
+						}
+					} else if err != nil {
+						t.Fatalf("step %d: unexpected error: %v", i, err)
+					}
+
+					if wait != step.wantWait {
+						t.Fatalf("step %d: got wait %s, want %s", i, wait, step.wantWait)
+					}
+
+				case "release":
+					limiter.release(step.client, now)
+
+				default:
+					t.Fatalf("step %d: unknown op %s", i, step.op)
+				}
+
+				if len(limiter.clients) != step.clients {
+					t.Fatalf("step %d: limiter keeps %d clients, want %d", i, len(limiter.clients), step.clients)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitedResponses(t *testing.T) {
+	cfg := config.Default()
+	cfg.RateLimit = 0.5
+	cfg.RateBurst = 1
+
+	router, closeRouter, err := newRouter(cfg)
+	if err != nil {
+		t.Fatalf("building the router: %v", err)
+	}
+	defer closeRouter()
+
+	serve := func(path string, remoteAddr string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.RemoteAddr = remoteAddr
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	if recorder := serve("/capabilities", "192.0.2.1:1234"); recorder.Code != http.StatusOK {
+		t.Fatalf("first request got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder := serve("/capabilities", "192.0.2.1:1234")
+
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request got %d, want %d", recorder.Code, http.StatusTooManyRequests)
+	}
+
+	//um token a cada dois segundos, arredondado para cima
+	if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Fatalf("got Retry-After %q, want 2", retryAfter)
+	}
+
+	if code := errorCodeOf(t, recorder); code != imgerrors.CodeRateLimited {
+		t.Fatalf("got code %s, want %s", code, imgerrors.CodeRateLimited)
+	}
+
+	if recorder := serve("/capabilities", "192.0.2.2:1234"); recorder.Code != http.StatusOK {
+		t.Fatalf("another client got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if recorder := serve("/healthz", "192.0.2.1:1234"); recorder.Code != http.StatusOK {
+		t.Fatalf("/healthz got %d, the probes are not rate limited", recorder.Code)
+	}
+}
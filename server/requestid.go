@@ -0,0 +1,45 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+//parte que dá um ID para cada requisição, o cliente pode mandar o seu para acompanhar o progresso
+
+const requestIDHeader = "X-Request-ID"
+
+const requestIDKey = "requestID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+func newRequestID() string {
+	id := make([]byte, 16)
+
+	_, err := rand.Read(id)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+func requestIDMiddleware(context *gin.Context) {
+	requestID := context.GetHeader(requestIDHeader)
+
+	if !validRequestID.MatchString(requestID) {
+		requestID = newRequestID()
+	}
+
+	context.Set(requestIDKey, requestID)
+	context.Header(requestIDHeader, requestID)
+
+	context.Next()
+}
+
+func getRequestID(context *gin.Context) string {
+	return context.GetString(requestIDKey)
+}
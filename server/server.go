@@ -1,402 +1,668 @@
 package server
 
 import (
+	"bytes"
+	stdcontext "context"
+	"encoding/json"
 	"errors"
-	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"img-ops/config"
 	"img-ops/imgconversion"
+	"img-ops/imgdata"
+	"img-ops/imgerrors"
 	"img-ops/imgprocessing"
-	"img-ops/imgstatistics"
+	"img-ops/jobs"
+
+	//registra as operações de histograma
+	_ "img-ops/imgstatistics"
 )
 
 //parte que lida com requisições
 
-type ErrorResponse struct {
-	Message string `json:"message"`
-}
+func getOutputFormatFromParams(context *gin.Context, defaultFormat string) (string, error) {
+	formatName := context.Query("format")
 
-func sendInputError(context *gin.Context, err error) {
-	errMessage := err.Error()
+	if formatName != "" {
+		return imgconversion.ParseFormat(formatName)
+	}
 
-	fmt.Printf("An error ocurred: %v\n", errMessage)
+	for _, acceptedType := range strings.Split(context.GetHeader("Accept"), ",") {
+		mediaType := strings.SplitN(acceptedType, ";", 2)[0]
 
-	errorResponse := ErrorResponse{
-		Message: errMessage,
+		format, ok := imgconversion.FormatFromContentType(mediaType)
+		if ok {
+			return format, nil
+		}
 	}
 
-	context.JSON(http.StatusBadRequest, errorResponse)
+	return defaultFormat, nil
 }
 
-func sendMatrixAsImg(context *gin.Context, matrix *[][][3]uint8) {
-	buf, err := imgconversion.CreatePNGBufferFromMatrix(matrix)
+func getEncodeOptionsFromParams(context *gin.Context, defaultFormat string) (imgconversion.EncodeOptions, error) {
+	options := imgconversion.DefaultEncodeOptions()
+
+	format, err := getOutputFormatFromParams(context, defaultFormat)
 	if err != nil {
-		sendInputError(context, err)
-		return
+		return options, err
 	}
+	options.Format = format
 
-	context.Data(http.StatusOK, "image/png", buf.Bytes())
-}
+	if qualityStr := context.Query("quality"); qualityStr != "" {
+		quality, err := strconv.Atoi(qualityStr)
+		if err != nil {
+			return options, imgerrors.InvalidParam("quality", "quality must be an integer")
+		}
+		options.Quality = quality
+	}
 
-func loadImgFromParams(context *gin.Context, name string) (*[][][3]uint8, error) {
-	multipartFile, _, err := context.Request.FormFile(name)
-	if err != nil {
-		return nil, err
+	if colorsStr := context.Query("colors"); colorsStr != "" {
+		colors, err := strconv.Atoi(colorsStr)
+		if err != nil {
+			return options, imgerrors.InvalidParam("colors", "colors must be an integer")
+		}
+		options.Colors = colors
 	}
 
-	matrix, err := imgconversion.LoadImg(multipartFile)
-	if err != nil {
-		return nil, err
+	if ditherStr := context.Query("dither"); ditherStr != "" {
+		dither, err := strconv.ParseBool(ditherStr)
+		if err != nil {
+			return options, imgerrors.InvalidParam("dither", "dither must be true or false")
+		}
+		options.Dither = dither
+	}
+
+	if asciiStr := context.Query("ascii"); asciiStr != "" {
+		ascii, err := strconv.ParseBool(asciiStr)
+		if err != nil {
+			return options, imgerrors.InvalidParam("ascii", "ascii must be true or false")
+		}
+		options.ASCII = ascii
+	}
+
+	if compression := context.Query("compression"); compression != "" {
+		options.TIFFCompression = compression
 	}
 
-	return matrix, nil
+	return options, nil
 }
 
-func getFactorFromParams(context *gin.Context) (float32, error) {
-	factorStr := context.Param("factor")
+//GIFs animados são devolvidos como GIF, a não ser que outro formato seja pedido
+
+func getAnimationEncodeOptions(context *gin.Context, animated bool) (imgconversion.EncodeOptions, error) {
+	defaultFormat := imgconversion.FormatPNG
+
+	if animated {
+		defaultFormat = imgconversion.FormatGIF
+	}
 
-	factor64, err := strconv.ParseFloat(factorStr, 32)
+	options, err := getEncodeOptionsFromParams(context, defaultFormat)
 	if err != nil {
-		return 0, err
+		return options, err
 	}
 
-	factor := float32(factor64)
+	if value, exists := context.Get(metadataKey); exists {
+		options.Exif = value.(*imgconversion.Metadata).Exif()
+	}
 
-	return factor, nil
+	return options, nil
 }
 
-func getMaskSizeFromParams(context *gin.Context) (int, error) {
-	maskSizeInt64, err := strconv.ParseInt(context.Param("maskSize"), 10, 64)
-	if err != nil {
-		return 0, err
+func writeAnimation(writer io.Writer, animation *imgconversion.Animation, options imgconversion.EncodeOptions) error {
+	start := time.Now()
+	defer func() {
+		encodeDuration.observe(time.Since(start).Seconds(), options.Format)
+	}()
+
+	if !animation.IsAnimated() || options.Format != imgconversion.FormatGIF {
+		return imgconversion.EncodeImage(writer, animation.Frames[0], options)
 	}
-	maskSize := int(maskSizeInt64)
 
-	return maskSize, nil
+	return imgconversion.EncodeAnimation(writer, animation, options)
 }
 
-func handleTwoImages(context *gin.Context, pixelOperation func(pixel1 uint8, pixel2 uint8) uint8) {
-	matrix1, err := loadImgFromParams(context, "img1")
+func encodeAnimation(animation *imgconversion.Animation, options imgconversion.EncodeOptions) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	err := writeAnimation(buf, animation, options)
 	if err != nil {
-		sendInputError(context, err)
-		return
+		return nil, err
 	}
 
-	matrix2, err := loadImgFromParams(context, "img2")
-	if err != nil {
-		sendInputError(context, err)
-		return
+	return buf.Bytes(), nil
+}
+
+func parseHexColor(hexColor string) ([3]uint8, error) {
+	var color [3]uint8
+
+	hexColor = strings.TrimPrefix(hexColor, "#")
+
+	if len(hexColor) != 6 {
+		return color, imgerrors.InvalidParam("background", "background must be a hex color like ffffff")
 	}
 
-	newMatrix := imgprocessing.OperateOnTwoMatrixes(matrix1, matrix2, pixelOperation)
+	for i := 0; i < 3; i++ {
+		value, err := strconv.ParseUint(hexColor[i*2:i*2+2], 16, 8)
+		if err != nil {
+			return color, imgerrors.InvalidParam("background", "background must be a hex color like ffffff")
+		}
+
+		color[i] = uint8(value)
+	}
 
-	sendMatrixAsImg(context, &newMatrix)
+	return color, nil
 }
 
-func handleOneImage(context *gin.Context, pixelOperation func(pixel uint8) uint8) {
-	matrix, err := loadImgFromParams(context, "img")
+func applyAlphaModeFromParams(context *gin.Context, img *imgdata.Image) (*imgdata.Image, error) {
+	alphaMode, err := imgdata.ParseAlphaMode(context.Query("alpha"))
 	if err != nil {
-		sendInputError(context, err)
-		return
+		return nil, err
 	}
 
-	imgprocessing.OperateOnMatrix(matrix, pixelOperation)
+	if alphaMode != imgdata.AlphaFlatten {
+		img.AlphaMode = alphaMode
+		return img, nil
+	}
 
-	sendMatrixAsImg(context, matrix)
+	background, err := parseHexColor(context.DefaultQuery("background", "ffffff"))
+	if err != nil {
+		return nil, err
+	}
+
+	return imgprocessing.FlattenImageAlpha(img, background), nil
 }
 
-func handleMaskOfOnesFilter(context *gin.Context, operation func(pixels []float64) uint8) {
-	matrix, err := loadImgFromParams(context, "img")
+func getLoadOptionsFromParams(context *gin.Context) (imgconversion.LoadOptions, error) {
+	cfg := getConfig(context)
+
+	options := imgconversion.LoadOptions{
+		MaxWidth:  cfg.MaxWidth,
+		MaxHeight: cfg.MaxHeight,
+		MaxPixels: cfg.MaxPixels,
+	}
+
+	if r, exists := getReservation(context); exists {
+		ctx := context.Request.Context()
+
+		options.Reserve = func(bytes int64) error {
+			return r.reserve(ctx, bytes)
+		}
+	}
+
+	if orientStr := context.Query("orient"); orientStr != "" {
+		orient, err := strconv.ParseBool(orientStr)
+		if err != nil {
+			return options, imgerrors.InvalidParam("orient", "orient must be true or false")
+		}
+		options.SkipOrientation = !orient
+	}
+
+	return options, nil
+}
+
+const metadataKey = "metadata"
+
+func loadAnimationFromParams(context *gin.Context, name string) (*imgconversion.Animation, error) {
+	upload, err := openUpload(context, name)
 	if err != nil {
-		sendInputError(context, err)
-		return
+		return nil, err
 	}
 
-	maskSize, err := getMaskSizeFromParams(context)
+	options, err := getLoadOptionsFromParams(context)
 	if err != nil {
-		sendInputError(context, err)
-		return
+		return nil, err
 	}
 
-	mask := imgprocessing.MakeMaskOfOnes(maskSize)
+	body := &bodyReader{reader: upload}
 
-	result := imgprocessing.ApplyFilter(matrix, mask, operation)
+	animation, metadata, err := decodeAnimation(context, body, options)
+	if body.err != nil {
+		return nil, uploadError(name, body.err)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	sendMatrixAsImg(context, result)
+	setInputMetadata(context, metadata)
+
+	return animation, nil
 }
 
-func corsMiddleware(context *gin.Context) {
-	context.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-	context.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-	context.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-	context.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
+func decodeAnimation(context *gin.Context, data io.Reader, options imgconversion.LoadOptions) (*imgconversion.Animation, *imgconversion.Metadata, error) {
+	start := time.Now()
 
-	if context.Request.Method == "OPTIONS" {
-		context.AbortWithStatus(204)
-		return
+	animation, metadata, err := imgconversion.LoadAnimationWithOptions(data, options)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	context.Next()
-}
+	decodeDuration.observe(time.Since(start).Seconds(), metadata.Format)
+	inputPixels.observe(float64(animation.Frames[0].Width*animation.Frames[0].Height*len(animation.Frames)), getEndpoint(context))
 
-func maxBodySizeMiddleware(c *gin.Context) {
-	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 3000000000)
+	for i, frame := range animation.Frames {
+		animation.Frames[i], err = applyAlphaModeFromParams(context, frame)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
-	c.Next()
+	return animation, metadata, nil
 }
 
-func StartServer() {
-	router := gin.Default()
+//operações aplicadas a cada quadro da imagem enviada
 
-	//lidar com duas imagens
+type imageOperation func(ctx stdcontext.Context, img *imgdata.Image) (*imgdata.Image, error)
 
-	router.POST("/process-img/add", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		handleTwoImages(context, imgprocessing.AddPixels)
-	})
+type twoImageOperation func(ctx stdcontext.Context, img1 *imgdata.Image, img2 *imgdata.Image) (*imgdata.Image, error)
 
-	router.POST("/process-img/subtract", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		handleTwoImages(context, imgprocessing.SubtractPixels)
-	})
+//cada quadro é uma parte igual do progresso total
+
+func applyToFrames(ctx stdcontext.Context, animation *imgconversion.Animation, operation imageOperation) (*imgconversion.Animation, error) {
+	var err error
 
-	router.POST("/process-img/blend/:factor", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		factor, err := getFactorFromParams(context)
+	frames := len(animation.Frames)
+
+	for i, frame := range animation.Frames {
+		animation.Frames[i], err = operation(imgprocessing.ScaleProgress(ctx, i, frames), frame)
 		if err != nil {
-			sendInputError(context, err)
-			return
+			return nil, err
 		}
 
-		handleTwoImages(context, imgprocessing.BlendPixelsCurry(factor))
-	})
-
-	router.POST("/process-img/avg", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		handleTwoImages(context, imgprocessing.AvgPixels)
-	})
+		imgprocessing.ReportProgress(ctx, i+1, frames)
+	}
 
-	router.POST("/process-img/and", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		handleTwoImages(context, imgprocessing.ANDPixels)
-	})
+	return animation, nil
+}
 
-	router.POST("/process-img/or", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		handleTwoImages(context, imgprocessing.ORPixels)
-	})
+//quando só uma das imagens é animada, a outra é usada em todos os quadros
 
-	router.POST("/process-img/xor", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		handleTwoImages(context, imgprocessing.XORPixels)
-	})
+func applyToFramePairs(ctx stdcontext.Context, animation1 *imgconversion.Animation, animation2 *imgconversion.Animation, operation twoImageOperation) (*imgconversion.Animation, error) {
+	longest := animation1
 
-	//lidar com uma imagem
+	if len(animation2.Frames) > len(animation1.Frames) {
+		longest = animation2
+	}
 
-	router.POST("/process-img/multiply/:factor", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		factor, err := getFactorFromParams(context)
-		if err != nil {
-			sendInputError(context, err)
-			return
-		}
+	newAnimation := &imgconversion.Animation{
+		Delays:    longest.Delays,
+		Disposals: longest.Disposals,
+		LoopCount: longest.LoopCount,
+	}
 
-		handleOneImage(context, imgprocessing.MultiplyPixelCurry(factor))
-	})
+	for i := range longest.Frames {
+		frame1 := animation1.Frames[i%len(animation1.Frames)]
+		frame2 := animation2.Frames[i%len(animation2.Frames)]
 
-	router.POST("/process-img/divide/:factor", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		factor, err := getFactorFromParams(context)
+		newFrame, err := operation(imgprocessing.ScaleProgress(ctx, i, len(longest.Frames)), frame1, frame2)
 		if err != nil {
-			sendInputError(context, err)
-			return
+			return nil, err
 		}
 
-		handleOneImage(context, imgprocessing.MultiplyPixelCurry(1/factor))
-	})
+		newAnimation.Frames = append(newAnimation.Frames, newFrame)
 
-	router.POST("/process-img/not", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		matrix, err := loadImgFromParams(context, "img")
-		if err != nil {
-			sendInputError(context, err)
-			return
-		}
+		imgprocessing.ReportProgress(ctx, i+1, len(longest.Frames))
+	}
 
-		imgprocessing.NOTMatrix(matrix)
+	return newAnimation, nil
+}
 
-		sendMatrixAsImg(context, matrix)
+func handleImageOperation(context *gin.Context, identity string, operation imageOperation) {
+	sendCachedAnimation(context, identity, []string{"img"}, func(inputs []*imgconversion.Animation) (*imgconversion.Animation, error) {
+		return applyToFrames(context.Request.Context(), inputs[0], operation)
 	})
+}
 
-	router.POST("/process-img/grayscale", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		matrix, err := loadImgFromParams(context, "img")
-		if err != nil {
-			sendInputError(context, err)
-			return
-		}
+func handleTwoImageOperation(context *gin.Context, identity string, operation twoImageOperation) {
+	sendCachedAnimation(context, identity, []string{"img1", "img2"}, func(inputs []*imgconversion.Animation) (*imgconversion.Animation, error) {
+		return applyToFramePairs(context.Request.Context(), inputs[0], inputs[1], operation)
+	})
+}
 
-		imgprocessing.ConvertMatrixToGrayscale(matrix)
+//rotas geradas a partir do registro de operações, parâmetros obrigatórios vão no caminho e opcionais na query
 
-		sendMatrixAsImg(context, matrix)
-	})
+func operationRoute(op *imgprocessing.Operation) string {
+	route := "/process-img/" + op.Name
 
-	router.POST("/process-img/binary", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		matrix, err := loadImgFromParams(context, "img")
-		if err != nil {
-			sendInputError(context, err)
-			return
+	for _, param := range op.Params {
+		if param.Required() {
+			route += "/:" + param.Name
 		}
+	}
 
-		imgprocessing.ConvertMatrixToBinary(matrix)
+	return route
+}
 
-		sendMatrixAsImg(context, matrix)
-	})
+func getOperationParams(context *gin.Context, op *imgprocessing.Operation) (imgprocessing.Params, error) {
+	values := map[string]string{}
 
-	router.POST("/process-img/equalize-histogram", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		matrix, err := loadImgFromParams(context, "img")
-		if err != nil {
-			sendInputError(context, err)
-			return
+	for _, param := range op.Params {
+		if param.Required() {
+			values[param.Name] = context.Param(param.Name)
+			continue
 		}
 
-		imgprocessing.EqualizeMatrixHistogram(matrix)
+		if value, exists := context.GetQuery(param.Name); exists {
+			values[param.Name] = value
+		}
+	}
 
-		sendMatrixAsImg(context, matrix)
-	})
+	return op.ParseParams(values)
+}
 
-	router.POST("/process-img/histogram", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		matrix, err := loadImgFromParams(context, "img")
+func handleRegisteredOperation(op *imgprocessing.Operation) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		params, err := getOperationParams(context, op)
 		if err != nil {
-			sendInputError(context, err)
+			sendError(context, err)
 			return
 		}
 
-		histMatrix, err := imgstatistics.GetMatrixHistRGB(matrix)
+		//json ordena as chaves do mapa, então os mesmos parâmetros sempre geram o mesmo texto
+		paramsJSON, err := json.Marshal(params)
 		if err != nil {
-			sendInputError(context, err)
+			sendError(context, err)
 			return
 		}
 
-		sendMatrixAsImg(context, histMatrix)
-	})
+		identity := op.Name + " " + string(paramsJSON)
 
-	router.POST("/process-img/compare-histograms", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		matrix1, err := loadImgFromParams(context, "img1")
-		if err != nil {
-			sendInputError(context, err)
+		if op.Arity == 2 {
+			handleTwoImageOperation(context, identity, func(ctx stdcontext.Context, img1 *imgdata.Image, img2 *imgdata.Image) (*imgdata.Image, error) {
+				return op.Apply(ctx, img1, img2, params)
+			})
 			return
 		}
 
-		matrix2, err := loadImgFromParams(context, "img2")
-		if err != nil {
-			sendInputError(context, err)
-			return
-		}
+		handleImageOperation(context, identity, func(ctx stdcontext.Context, img *imgdata.Image) (*imgdata.Image, error) {
+			return op.Apply(ctx, img, nil, params)
+		})
+	}
+}
 
-		result, err := imgstatistics.CompareHistograms(matrix1, matrix2)
-		if err != nil {
-			sendInputError(context, err)
-			return
+//só devolve o cabeçalho de CORS para as origens permitidas
+
+func newCORSMiddleware(origins []string) gin.HandlerFunc {
+	allowed := map[string]bool{}
+
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+
+	return func(context *gin.Context) {
+		origin := context.GetHeader("Origin")
+
+		if allowed["*"] {
+			context.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		} else if allowed[origin] {
+			context.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+			context.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+			context.Writer.Header().Add("Vary", "Origin")
 		}
 
-		sendMatrixAsImg(context, result)
-	})
+		context.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, X-Request-ID, accept, origin, Cache-Control, X-Requested-With")
+		context.Writer.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Retry-After, Location, ETag, X-Cache")
+		context.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
 
-	router.POST("/process-img/equalize-and-compare-histograms", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		matrix, err := loadImgFromParams(context, "img")
-		if err != nil {
-			sendInputError(context, err)
+		if context.Request.Method == "OPTIONS" {
+			context.AbortWithStatus(204)
 			return
 		}
 
-		matrixOld := imgprocessing.CopyMatrix(matrix)
+		context.Next()
+	}
+}
 
-		imgprocessing.EqualizeMatrixHistogram(matrix)
+func newMaxBodySizeMiddleware(maxBodySize int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize)
 
-		result, err := imgstatistics.CompareHistograms(matrixOld, matrix)
-		if err != nil {
-			sendInputError(context, err)
+		c.Next()
+	}
+}
+
+//cada requisição recebe um prazo, as operações param quando ele acaba ou o cliente desconecta
+
+//a sessão de edição limita cada mensagem separadamente, e os eventos acabam junto com o job ou a requisição acompanhada
+
+var longLivedRoutes = map[string]bool{
+	sessionRoute:           true,
+	"/jobs/:id/events":     true,
+	"/requests/:id/events": true,
+}
+
+func newTimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if timeout <= 0 || longLivedRoutes[context.FullPath()] {
+			context.Next()
 			return
 		}
 
-		sendMatrixAsImg(context, result)
-	})
+		ctx, cancel := stdcontext.WithTimeout(context.Request.Context(), timeout)
+		defer cancel()
 
-	router.POST("/process-img/filter/max/:maskSize", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		handleMaskOfOnesFilter(context, imgprocessing.PixelsMax)
-	})
+		context.Request = context.Request.WithContext(ctx)
 
-	router.POST("/process-img/filter/min/:maskSize", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		handleMaskOfOnesFilter(context, imgprocessing.PixelsMin)
-	})
+		context.Next()
+	}
+}
 
-	router.POST("/process-img/filter/avg/:maskSize", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		handleMaskOfOnesFilter(context, imgprocessing.PixelsAvg)
-	})
+const configKey = "config"
 
-	router.POST("/process-img/filter/mean/:maskSize", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		handleMaskOfOnesFilter(context, imgprocessing.PixelsMean)
-	})
+func newConfigMiddleware(cfg config.Config) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		context.Set(configKey, cfg)
 
-	router.POST("/process-img/filter/conservative-smoothing/:maskSize", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		handleMaskOfOnesFilter(context, imgprocessing.GetPixelBoundedByNeighborsRange)
-	})
+		context.Next()
+	}
+}
 
-	router.POST("/process-img/filter/order/:maskSize/:index", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		maskSize, err := getMaskSizeFromParams(context)
-		if err != nil {
-			sendInputError(context, err)
-			return
+func getConfig(context *gin.Context) config.Config {
+	if value, exists := context.Get(configKey); exists {
+		return value.(config.Config)
+	}
+
+	return config.Default()
+}
+
+func StartServer(cfg config.Config) error {
+	setLogLevel(cfg.LogLevel)
+
+	if cfg.LogLevel == "debug" {
+		gin.SetMode(gin.DebugMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	router, closeRouter, err := newRouter(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRouter()
+
+	return serve(cfg, router)
+}
+
+//monta as rotas e middlewares sem abrir a porta, o close para os workers dos jobs
+
+func newRouter(cfg config.Config) (*gin.Engine, func(), error) {
+	router := gin.New()
+
+	//sem proxies confiáveis o IP do cliente é o da conexão, e cabeçalhos enviados por qualquer um não mudam os limites por cliente
+	err := router.SetTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	progress := newProgressRegistry()
+
+	router.NoRoute(handleNoRoute)
+
+	router.Use(requestIDMiddleware)
+
+	if logEnabled("info") {
+		router.Use(requestLogMiddleware)
+	}
+
+	router.Use(metricsMiddleware, gin.CustomRecovery(handlePanic), newCORSMiddleware(cfg.CORSOrigins), newMaxBodySizeMiddleware(cfg.MaxBodySize), newConfigMiddleware(cfg))
+
+	if cfg.AuthEnabled() {
+		authenticators := []authenticator{}
+
+		if len(cfg.APIKeys) > 0 {
+			authenticators = append(authenticators, newAPIKeyAuthenticator(cfg.APIKeys))
+		}
+
+		if cfg.AuthSecret != "" {
+			authenticators = append(authenticators, newTokenAuthenticator(cfg.AuthSecret))
 		}
 
-		index64, err := strconv.ParseInt(context.Param("index"), 10, 64)
+		router.Use(newAuthMiddleware(authenticators...))
+	}
+
+	if cfg.RateLimit > 0 || cfg.ClientConcurrency > 0 {
+		router.Use(newRateLimitMiddleware(newClientLimiter(cfg.RateLimit, cfg.RateBurst, cfg.ClientConcurrency)))
+	}
+
+	router.Use(newTimeoutMiddleware(cfg.RequestTimeout))
+
+	if cfg.CacheEnabled() {
+		resultCache, err := newResultCache(cfg)
 		if err != nil {
-			sendInputError(context, err)
-			return
+			return nil, nil, err
 		}
-		index := int(index64)
 
-		maxIndex := maskSize*maskSize - 1
-		maxIndexStr := strconv.Itoa(int(maxIndex))
+		router.Use(newCacheMiddleware(resultCache))
+	}
 
-		if index < 0 || index > maxIndex {
-			sendInputError(context, errors.New("index must be between 0 and "+maxIndexStr))
-			return
+	budget := newMemoryBudget(cfg.MemoryBudget, cfg.MemoryWait)
+
+	router.Use(newMemoryBudgetMiddleware(budget), newProgressMiddleware(progress))
+
+	endpoints := map[string]bool{}
+	enabledEndpoints := []endpointInfo{}
+
+	addEndpoint := func(name string, method string, route string, handler gin.HandlerFunc) {
+		endpoints[name] = true
+
+		if cfg.EndpointEnabled(name) {
+			router.Handle(method, route, withEndpoint(name, handler))
+			enabledEndpoints = append(enabledEndpoints, endpointInfo{Name: name, Method: method, Route: route})
 		}
+	}
+
+	//as sondas do orquestrador não podem ser desligadas pela configuração
+	router.GET("/healthz", withEndpoint("healthz", handleHealth))
 
-		matrix, err := loadImgFromParams(context, "img")
+	for _, op := range imgprocessing.Operations() {
+		addEndpoint(op.Name, http.MethodPost, operationRoute(op), handleRegisteredOperation(op))
+	}
+
+	addEndpoint("metadata", http.MethodPost, "/process-img/metadata", func(context *gin.Context) {
+		metadata, err := loadMetadataFromStream(context, "img")
 		if err != nil {
-			sendInputError(context, err)
+			sendError(context, err)
 			return
 		}
 
-		mask := imgprocessing.MakeMaskOfOnes(maskSize)
+		context.JSON(http.StatusOK, metadata)
+	})
+
+	addEndpoint("pipeline", http.MethodPost, "/process-img/pipeline", handlePipeline)
+
+	addEndpoint("batch", http.MethodPost, "/process-img/batch", handleBatch)
+
+	addEndpoint("session", http.MethodGet, sessionRoute, handleSession)
+
+	jobManager := jobs.NewManager(jobs.NewMemoryStore(), cfg.JobWorkers, cfg.JobQueueSize, cfg.JobTTL)
 
-		getPixelByIndexInSortedArr := imgprocessing.GetPixelByIndexInSortedArrCurry(index)
+	addEndpoint("jobs", http.MethodPost, "/jobs", handleCreateJob(jobManager))
+	addEndpoint("jobs", http.MethodGet, "/jobs/:id", handleGetJob(jobManager))
+	addEndpoint("jobs", http.MethodGet, "/jobs/:id/result", handleGetJobResult(jobManager))
+	addEndpoint("jobs", http.MethodGet, "/jobs/:id/events", handleJobEvents(jobManager))
 
-		result := imgprocessing.ApplyFilter(matrix, mask, getPixelByIndexInSortedArr)
+	router.GET("/readyz", withEndpoint("readyz", newReadinessHandler(jobManager, budget)))
 
-		sendMatrixAsImg(context, result)
+	//progresso de uma requisição em andamento do mesmo cliente, identificada pelo cabeçalho X-Request-ID que ele enviou
+	addEndpoint("events", http.MethodGet, "/requests/:id/events", handleRequestEvents(progress))
+
+	addEndpoint("metrics", http.MethodGet, "/metrics", handleMetrics)
+
+	addEndpoint("capabilities", http.MethodGet, "/capabilities", newCapabilitiesHandler(cfg, &enabledEndpoints))
+
+	var openAPI gin.H
+
+	addEndpoint("openapi", http.MethodGet, "/openapi.json", func(context *gin.Context) {
+		context.JSON(http.StatusOK, openAPI)
 	})
 
-	router.POST("/process-img/filter/gaussian/:maskSize/:sigma", corsMiddleware, maxBodySizeMiddleware, func(context *gin.Context) {
-		matrix, err := loadImgFromParams(context, "img")
-		if err != nil {
-			sendInputError(context, err)
-			return
+	for _, name := range cfg.EnabledEndpoints {
+		if !endpoints[name] {
+			jobManager.Close()
+			return nil, nil, errors.New("unknown endpoint " + name + " in enabled endpoints")
 		}
+	}
 
-		maskSize, err := getMaskSizeFromParams(context)
-		if err != nil {
-			sendInputError(context, err)
-			return
-		}
+	openAPI, err = buildOpenAPI(router.Routes(), cfg.AuthEnabled())
+	if err != nil {
+		jobManager.Close()
+		return nil, nil, err
+	}
 
-		sigma, err := strconv.ParseFloat(context.Param("sigma"), 64)
-		if err != nil {
-			sendInputError(context, err)
-			return
+	return router, jobManager.Close, nil
+}
+
+//roda até receber SIGINT ou SIGTERM, depois espera as requisições em andamento terminarem
+
+func serve(cfg config.Config, handler http.Handler) error {
+	httpServer := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: handler,
+	}
+
+	serverErr := make(chan error, 1)
+
+	go func() {
+		logf("info", "listening on %v", cfg.Addr)
+
+		if cfg.UsesTLS() {
+			serverErr <- httpServer.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+		} else {
+			serverErr <- httpServer.ListenAndServe()
 		}
+	}()
 
-		gaussMask := imgprocessing.MakeGaussMask(maskSize, sigma)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
 
-		result := imgprocessing.ApplyFilter(matrix, gaussMask, imgprocessing.PixelsSum)
+	select {
+	case err := <-serverErr:
+		return err
+	case sig := <-signals:
+		logf("info", "received %v, shutting down", sig)
 
-		sendMatrixAsImg(context, result)
-	})
+		atomic.StoreInt32(&shuttingDown, 1)
+	}
+
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	err := httpServer.Shutdown(ctx)
+	if err != nil {
+		logf("warn", "requests still running after %v, closing connections", cfg.ShutdownTimeout)
+
+		return httpServer.Close()
+	}
 
-	router.Run("localhost:9090")
+	return nil
 }
@@ -0,0 +1,652 @@
+package server
+
+import (
+	"bytes"
+	stdcontext "context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"img-ops/config"
+	"img-ops/imgconversion"
+	"img-ops/imgerrors"
+	"img-ops/imgprocessing"
+)
+
+//parte que mantém uma imagem aberta numa conexão WebSocket, para o editor mandar só as operações e receber prévias, com desfazer e refazer
+
+const sessionRoute = "/process-img/session"
+
+const sessionWriteTimeout = time.Minute
+
+//mensagens do cliente, load também pode chegar como uma mensagem binária com a imagem
+
+type sessionMessage struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+
+	//load, image em base64 ou data URI, name img2 carrega a segunda imagem das operações de duas imagens
+	Image string `json:"image,omitempty"`
+	Name  string `json:"name,omitempty"`
+
+	//apply e preview, uma operação ou uma lista de passos como no pipeline
+	Op     string             `json:"op,omitempty"`
+	Params map[string]float64 `json:"params,omitempty"`
+	Steps  []pipelineStep     `json:"steps,omitempty"`
+
+	//valem para as próximas prévias até serem mudados, 0 em previewSize é o tamanho original
+	PreviewSize   *int   `json:"previewSize,omitempty"`
+	PreviewFormat string `json:"previewFormat,omitempty"`
+
+	//export
+	Format  string `json:"format,omitempty"`
+	Quality int    `json:"quality,omitempty"`
+
+	encoded []byte
+	err     error
+}
+
+type sessionResponse struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+
+	Width    int      `json:"width,omitempty"`
+	Height   int      `json:"height,omitempty"`
+	Frames   int      `json:"frames,omitempty"`
+	History  []string `json:"history,omitempty"`
+	Position int      `json:"position"`
+	CanUndo  bool     `json:"canUndo"`
+	CanRedo  bool     `json:"canRedo"`
+
+	ContentType string         `json:"contentType,omitempty"`
+	Data        []byte         `json:"data,omitempty"`
+	Error       *ErrorResponse `json:"error,omitempty"`
+}
+
+//cada versão da imagem segura a sua parte do orçamento de memória até sair do histórico
+
+type sessionEntry struct {
+	label     string
+	animation *imgconversion.Animation
+	bytes     int64
+}
+
+type editSession struct {
+	context *gin.Context
+	cfg     config.Config
+	budget  *memoryBudget
+
+	history  []sessionEntry
+	position int
+	second   *sessionEntry
+	metadata *imgconversion.Metadata
+
+	previewSize   int
+	previewFormat string
+}
+
+func animationBytes(animation *imgconversion.Animation) int64 {
+	var size int64
+
+	for _, frame := range animation.Frames {
+		if frame != nil {
+			size += int64(len(frame.Pix))
+		}
+	}
+
+	return size
+}
+
+func (session *editSession) keep(ctx stdcontext.Context, label string, animation *imgconversion.Animation) (sessionEntry, error) {
+	entry := sessionEntry{label: label, animation: animation, bytes: animationBytes(animation)}
+
+	return entry, session.budget.acquire(ctx, entry.bytes)
+}
+
+func (session *editSession) drop(entries ...sessionEntry) {
+	for _, entry := range entries {
+		session.budget.release(entry.bytes)
+	}
+}
+
+func (session *editSession) close() {
+	session.drop(session.history...)
+	session.history = nil
+
+	if session.second != nil {
+		session.drop(*session.second)
+		session.second = nil
+	}
+}
+
+func (session *editSession) current() *imgconversion.Animation {
+	return session.history[session.position].animation
+}
+
+//a memória de decodificar e de calcular só fica reservada durante a mensagem
+
+func (session *editSession) load(ctx stdcontext.Context, msg sessionMessage) error {
+	var data io.Reader = bytes.NewReader(msg.encoded)
+
+	name := msg.Name
+	if name == "" {
+		name = "img"
+	}
+
+	if name != "img" && name != "img2" {
+		return imgerrors.InvalidParam("name", "name must be img or img2")
+	}
+
+	if msg.encoded == nil {
+		if msg.Image == "" {
+			return imgerrors.InvalidParam("image", "missing image")
+		}
+
+		reader, err := base64Reader("image", msg.Image)
+		if err != nil {
+			return err
+		}
+
+		data = reader
+	}
+
+	options, err := getLoadOptionsFromParams(session.context)
+	if err != nil {
+		return err
+	}
+
+	messageReservation := &reservation{budget: session.budget}
+	defer messageReservation.releaseAll()
+
+	options.Reserve = func(bytes int64) error {
+		return messageReservation.reserve(ctx, bytes)
+	}
+
+	body := &bodyReader{reader: data}
+
+	animation, metadata, err := decodeAnimation(session.context, body, options)
+	if body.err != nil {
+		return uploadError("image", body.err)
+	}
+	if err != nil {
+		return err
+	}
+
+	entry, err := session.keep(ctx, "load", animation)
+	if err != nil {
+		return err
+	}
+
+	if name == "img2" {
+		if session.second != nil {
+			session.drop(*session.second)
+		}
+
+		session.second = &entry
+		return nil
+	}
+
+	session.close()
+	session.history = []sessionEntry{entry}
+	session.position = 0
+	session.metadata = metadata
+
+	return nil
+}
+
+func (session *editSession) compute(ctx stdcontext.Context, msg sessionMessage) (string, *imgconversion.Animation, error) {
+	if len(session.history) == 0 {
+		return "", nil, imgerrors.New(imgerrors.CodeConflict, "load an image first")
+	}
+
+	label := "pipeline"
+	steps := msg.Steps
+
+	if msg.Op != "" {
+		if _, exists := imgprocessing.LookupOperation(msg.Op); !exists || !session.cfg.EndpointEnabled(msg.Op) {
+			return "", nil, imgerrors.InvalidParam("op", "unknown operation "+msg.Op)
+		}
+
+		label = msg.Op
+		steps = []pipelineStep{{Op: msg.Op, Params: msg.Params}}
+	}
+
+	operation, needsSecondImage, err := buildPipeline(steps, session.cfg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	second := imgconversion.NewStaticAnimation(nil)
+
+	if needsSecondImage {
+		if session.second == nil {
+			return "", nil, imgerrors.InvalidParam("img2", "load img2 before using an operation that takes two images")
+		}
+
+		second = session.second.animation
+	}
+
+	current := session.current()
+
+	messageReservation := &reservation{budget: session.budget}
+	defer messageReservation.releaseAll()
+
+	err = messageReservation.reserve(ctx, imgconversion.EstimateWorkingSet(current.Frames[0].Width, current.Frames[0].Height, len(current.Frames)))
+	if err != nil {
+		return "", nil, err
+	}
+
+	//as operações recebem cópias, o histórico continua valendo para desfazer e refazer mesmo que alguma escreva na entrada
+	err = messageReservation.reserve(ctx, animationBytes(current)+animationBytes(second))
+	if err != nil {
+		return "", nil, err
+	}
+
+	current = current.Copy()
+	second = second.Copy()
+
+	result, err := applyToFramePairs(ctx, current, second, operation)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return label, result, nil
+}
+
+//aplicar depois de desfazer descarta o que podia ser refeito, e o histórico perde as versões mais antigas quando passa do limite
+
+func (session *editSession) apply(ctx stdcontext.Context, msg sessionMessage) (*imgconversion.Animation, error) {
+	label, result, err := session.compute(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := session.keep(ctx, label, result)
+	if err != nil {
+		return nil, err
+	}
+
+	session.drop(session.history[session.position+1:]...)
+	session.history = append(session.history[:session.position+1], entry)
+	session.position++
+
+	if len(session.history) > session.cfg.SessionHistory+1 {
+		removed := len(session.history) - session.cfg.SessionHistory - 1
+
+		session.drop(session.history[:removed]...)
+		session.history = append([]sessionEntry{}, session.history[removed:]...)
+		session.position -= removed
+	}
+
+	return result, nil
+}
+
+func (session *editSession) move(step int) error {
+	if len(session.history) == 0 {
+		return imgerrors.New(imgerrors.CodeConflict, "load an image first")
+	}
+
+	position := session.position + step
+
+	if position < 0 {
+		return imgerrors.New(imgerrors.CodeConflict, "nothing to undo")
+	}
+
+	if position >= len(session.history) {
+		return imgerrors.New(imgerrors.CodeConflict, "nothing to redo")
+	}
+
+	session.position = position
+
+	return nil
+}
+
+func (session *editSession) setPreviewOptions(msg sessionMessage) error {
+	if msg.PreviewSize != nil {
+		if *msg.PreviewSize < 0 {
+			return imgerrors.InvalidParam("previewSize", "previewSize must not be negative")
+		}
+
+		session.previewSize = *msg.PreviewSize
+	}
+
+	if msg.PreviewFormat != "" {
+		format, err := imgconversion.ParseFormat(msg.PreviewFormat)
+		if err != nil {
+			return err
+		}
+
+		session.previewFormat = format
+	}
+
+	return nil
+}
+
+//a prévia é reduzida para caber em previewSize, o histórico guarda sempre a resolução original
+
+func (session *editSession) preview(ctx stdcontext.Context, animation *imgconversion.Animation) (string, []byte, error) {
+	width, height := animation.Frames[0].Width, animation.Frames[0].Height
+	size := session.previewSize
+
+	if size > 0 && (width > size || height > size) {
+		newWidth, newHeight := size, height*size/width
+
+		if height > width {
+			newWidth, newHeight = width*size/height, size
+		}
+
+		if newWidth < 1 {
+			newWidth = 1
+		}
+
+		if newHeight < 1 {
+			newHeight = 1
+		}
+
+		scaled := &imgconversion.Animation{Delays: animation.Delays, Disposals: animation.Disposals, LoopCount: animation.LoopCount}
+
+		for _, frame := range animation.Frames {
+			scaledFrame, err := imgprocessing.ResizeImageNearestNeighborContext(ctx, frame, uint64(newWidth), uint64(newHeight))
+			if err != nil {
+				return "", nil, err
+			}
+
+			scaled.Frames = append(scaled.Frames, scaledFrame)
+		}
+
+		animation = scaled
+	}
+
+	options := imgconversion.DefaultEncodeOptions()
+	options.Format = session.previewFormat
+
+	if options.Format == "" {
+		options.Format = imgconversion.FormatPNG
+
+		if animation.IsAnimated() {
+			options.Format = imgconversion.FormatGIF
+		}
+	}
+
+	data, err := encodeAnimation(animation, options)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return imgconversion.ContentTypeOf(options.Format), data, nil
+}
+
+func (session *editSession) export(msg sessionMessage) (string, []byte, error) {
+	if len(session.history) == 0 {
+		return "", nil, imgerrors.New(imgerrors.CodeConflict, "load an image first")
+	}
+
+	animation := session.current()
+
+	options := imgconversion.DefaultEncodeOptions()
+	options.Format = imgconversion.FormatPNG
+
+	if animation.IsAnimated() {
+		options.Format = imgconversion.FormatGIF
+	}
+
+	if msg.Format != "" {
+		format, err := imgconversion.ParseFormat(msg.Format)
+		if err != nil {
+			return "", nil, err
+		}
+
+		options.Format = format
+	}
+
+	if msg.Quality != 0 {
+		options.Quality = msg.Quality
+	}
+
+	if session.metadata != nil {
+		options.Exif = session.metadata.Exif()
+	}
+
+	data, err := encodeAnimation(animation, options)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return imgconversion.ContentTypeOf(options.Format), data, nil
+}
+
+func (session *editSession) state(response *sessionResponse) {
+	if len(session.history) == 0 {
+		return
+	}
+
+	current := session.current()
+
+	response.Width = current.Frames[0].Width
+	response.Height = current.Frames[0].Height
+	response.Frames = len(current.Frames)
+	response.Position = session.position
+	response.CanUndo = session.position > 0
+	response.CanRedo = session.position < len(session.history)-1
+
+	for _, entry := range session.history {
+		response.History = append(response.History, entry.label)
+	}
+}
+
+func (session *editSession) handle(ctx stdcontext.Context, msg sessionMessage) (*sessionResponse, error) {
+	if msg.err != nil {
+		return nil, msg.err
+	}
+
+	err := session.setPreviewOptions(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &sessionResponse{ID: msg.ID, Type: msg.Type}
+
+	var shown *imgconversion.Animation
+
+	switch msg.Type {
+	case "load":
+		err = session.load(ctx, msg)
+		if err == nil && len(session.history) > 0 {
+			shown = session.current()
+		}
+	case "apply":
+		shown, err = session.apply(ctx, msg)
+	case "preview":
+		_, shown, err = session.compute(ctx, msg)
+	case "undo", "redo":
+		step := -1
+		if msg.Type == "redo" {
+			step = 1
+		}
+
+		err = session.move(step)
+		if err == nil {
+			shown = session.current()
+		}
+	case "export":
+		response.ContentType, response.Data, err = session.export(msg)
+	default:
+		err = imgerrors.InvalidParam("type", "unknown message type "+msg.Type+", expected load, apply, preview, undo, redo or export")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if shown != nil {
+		response.ContentType, response.Data, err = session.preview(ctx, shown)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	session.state(response)
+
+	return response, nil
+}
+
+//uma prévia que já foi substituída por outra mais nova, como as de um slider, não é calculada nem respondida
+
+func latestMessage(msg sessionMessage, messages <-chan sessionMessage) (sessionMessage, *sessionMessage) {
+	for msg.Type == "preview" {
+		select {
+		case next, ok := <-messages:
+			if !ok {
+				return msg, nil
+			}
+
+			if next.Type != "preview" {
+				return msg, &next
+			}
+
+			msg = next
+		default:
+			return msg, nil
+		}
+	}
+
+	return msg, nil
+}
+
+func readSessionMessages(ctx stdcontext.Context, conn *websocket.Conn, idleTimeout time.Duration, messages chan<- sessionMessage) {
+	defer close(messages)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		msg := sessionMessage{Type: "load", encoded: data}
+
+		if messageType == websocket.TextMessage {
+			msg = sessionMessage{}
+
+			err = json.Unmarshal(data, &msg)
+			if err != nil {
+				msg.err = imgerrors.InvalidParam("message", "message must be a JSON object: "+err.Error())
+			}
+		}
+
+		select {
+		case messages <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func newSessionUpgrader(cfg config.Config, context *gin.Context) *websocket.Upgrader {
+	allowed := map[string]bool{}
+
+	for _, origin := range cfg.CORSOrigins {
+		allowed[origin] = true
+	}
+
+	return &websocket.Upgrader{
+		//navegadores mandam Origin, e qualquer página poderia abrir uma sessão com as credenciais do usuário
+		CheckOrigin: func(request *http.Request) bool {
+			origin := request.Header.Get("Origin")
+
+			return origin == "" || allowed["*"] || allowed[origin]
+		},
+		Error: func(writer http.ResponseWriter, request *http.Request, status int, reason error) {
+			if status == http.StatusForbidden {
+				sendError(context, imgerrors.New(imgerrors.CodeUnauthorized, "origin not allowed"))
+				return
+			}
+
+			sendError(context, &imgerrors.Error{Code: imgerrors.CodeInvalidParameter, Message: reason.Error(), Err: reason})
+		},
+	}
+}
+
+//cada mensagem é tratada na ordem em que chegou e tem o mesmo limite de tempo de uma requisição
+
+func handleSession(context *gin.Context) {
+	cfg := getConfig(context)
+
+	//o Upgrade escreve a resposta direto na conexão, o status fica registrado para os logs e as métricas
+	context.Status(http.StatusSwitchingProtocols)
+
+	conn, err := newSessionUpgrader(cfg, context).Upgrade(context.Writer, context.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	conn.SetReadLimit(cfg.MaxBodySize)
+
+	session := &editSession{context: context, cfg: cfg, budget: newMemoryBudget(0, 0)}
+	defer session.close()
+
+	if requestReservation, exists := getReservation(context); exists {
+		session.budget = requestReservation.budget
+	}
+
+	ctx, cancel := stdcontext.WithCancel(context.Request.Context())
+	defer cancel()
+
+	messages := make(chan sessionMessage, 16)
+
+	go readSessionMessages(ctx, conn, cfg.SessionIdleTimeout, messages)
+
+	logWith("info", logFields{"requestId": getRequestID(context), "client": getClient(context)}, "editing session opened")
+
+	var pending *sessionMessage
+
+	for {
+		var msg sessionMessage
+
+		if pending != nil {
+			msg, pending = *pending, nil
+		} else {
+			next, ok := <-messages
+			if !ok {
+				break
+			}
+
+			msg = next
+		}
+
+		msg, pending = latestMessage(msg, messages)
+
+		messageCtx, cancelMessage := ctx, stdcontext.CancelFunc(func() {})
+		if cfg.RequestTimeout > 0 {
+			messageCtx, cancelMessage = stdcontext.WithTimeout(ctx, cfg.RequestTimeout)
+		}
+
+		response, err := session.handle(messageCtx, msg)
+		cancelMessage()
+
+		if err != nil {
+			typedErr := classifyError(context, err)
+
+			logWith("warn", logFields{"requestId": getRequestID(context), "code": typedErr.Code, "message": msg.Type, "error": err.Error()}, "session message rejected")
+
+			errResponse := newErrorResponse(context, typedErr)
+			response = &sessionResponse{ID: msg.ID, Type: "error", Error: &errResponse}
+			session.state(response)
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+
+		err = conn.WriteJSON(response)
+		if err != nil {
+			break
+		}
+	}
+
+	logWith("info", logFields{"requestId": getRequestID(context), "client": getClient(context)}, "editing session closed")
+}
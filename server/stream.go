@@ -0,0 +1,293 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"img-ops/imgconversion"
+	"img-ops/imgerrors"
+)
+
+//parte que decodifica as imagens direto do corpo multipart e escreve o resultado direto na resposta, sem guardar os arquivos inteiros
+
+const responseBufferSize = 64 << 10
+
+//guarda o erro de leitura do corpo, que o decodificador apresentaria como imagem inválida
+
+type bodyReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (reader *bodyReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+
+	if err != nil && err != io.EOF {
+		reader.err = err
+	}
+
+	return n, err
+}
+
+//as partes chegam na ordem em que o cliente mandou, campos e arquivos que não foram pedidos são pulados
+
+func nextUpload(reader *multipart.Reader, names []string, found []bool) (int, *multipart.Part, error) {
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return -1, nil, err
+		}
+
+		if part.FileName() != "" {
+			for i, name := range names {
+				if part.FormName() == name && !found[i] {
+					found[i] = true
+					return i, part, nil
+				}
+			}
+		}
+
+		part.Close()
+	}
+}
+
+func missingUpload(names []string, found []bool) error {
+	for i, name := range names {
+		if !found[i] {
+			return uploadError(name, http.ErrMissingFile)
+		}
+	}
+
+	return nil
+}
+
+//entrega as imagens pedidas na ordem em que chegam, no multipart sem guardar as partes e nos outros corpos pelo nome
+
+func readUploads(context *gin.Context, names []string, each func(i int, upload io.Reader) error) error {
+	readUpload := func(i int, upload io.Reader) error {
+		body := &bodyReader{reader: upload}
+
+		err := each(i, body)
+		if body.err != nil {
+			return uploadError(names[i], body.err)
+		}
+
+		return err
+	}
+
+	//um formulário que já foi lido por PostForm guardou os arquivos, então eles são abertos pelo nome
+	if bodyKind(context) != bodyMultipart || context.Request.MultipartForm != nil {
+		for i, name := range names {
+			upload, err := openUpload(context, name)
+			if err != nil {
+				return err
+			}
+
+			err = readUpload(i, upload)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	reader, err := context.Request.MultipartReader()
+	if err != nil {
+		return uploadError(names[0], err)
+	}
+
+	found := make([]bool, len(names))
+
+	for range names {
+		i, part, err := nextUpload(reader, names, found)
+		if err == io.EOF {
+			return missingUpload(names, found)
+		}
+		if err != nil {
+			return uploadError(names[0], err)
+		}
+
+		err = readUpload(i, part)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//os metadados da primeira imagem são repassados para a saída
+
+func setInputMetadata(context *gin.Context, metadata *imgconversion.Metadata) {
+	if _, exists := context.Get(metadataKey); !exists {
+		context.Set(metadataKey, metadata)
+	}
+}
+
+//decodifica cada imagem enquanto ela é recebida, os metadados da primeira são repassados para a saída
+
+func loadAnimationsFromStream(context *gin.Context, names ...string) ([]*imgconversion.Animation, error) {
+	options, err := getLoadOptionsFromParams(context)
+	if err != nil {
+		return nil, err
+	}
+
+	animations := make([]*imgconversion.Animation, len(names))
+	metadatas := make([]*imgconversion.Metadata, len(names))
+
+	err = readUploads(context, names, func(i int, upload io.Reader) error {
+		var err error
+
+		animations[i], metadatas[i], err = decodeAnimation(context, upload, options)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	setInputMetadata(context, metadatas[0])
+
+	return animations, nil
+}
+
+func loadMetadataFromStream(context *gin.Context, name string) (*imgconversion.Metadata, error) {
+	var upload io.Reader
+
+	if bodyKind(context) == bodyMultipart {
+		reader, err := context.Request.MultipartReader()
+		if err != nil {
+			return nil, uploadError(name, err)
+		}
+
+		found := []bool{false}
+
+		_, upload, err = nextUpload(reader, []string{name}, found)
+		if err == io.EOF {
+			return nil, missingUpload([]string{name}, found)
+		}
+		if err != nil {
+			return nil, uploadError(name, err)
+		}
+	} else {
+		var err error
+
+		upload, err = openUpload(context, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	body := &bodyReader{reader: upload}
+
+	metadata, err := imgconversion.LoadMetadata(body)
+	if body.err != nil {
+		return nil, uploadError(name, body.err)
+	}
+
+	return metadata, err
+}
+
+//com base64=true o resultado vem como {"contentType", "data"}, para clientes que não lidam bem com corpo binário
+
+func wantsBase64(context *gin.Context) (bool, error) {
+	base64Str := context.Query("base64")
+	if base64Str == "" {
+		return false, nil
+	}
+
+	asBase64, err := strconv.ParseBool(base64Str)
+	if err != nil {
+		return false, imgerrors.InvalidParam("base64", "base64 must be true or false")
+	}
+
+	return asBase64, nil
+}
+
+//sem Content-Length o net/http manda a resposta em partes, conforme o buffer enche, e o base64 também é escrito aos poucos
+
+func sendResult(context *gin.Context, contentType string, asBase64 bool, write func(writer io.Writer) error) error {
+	buffered := bufio.NewWriterSize(context.Writer, responseBufferSize)
+
+	if !asBase64 {
+		context.Header("Content-Type", contentType)
+		context.Status(http.StatusOK)
+
+		err := write(buffered)
+		if err != nil {
+			return err
+		}
+
+		return buffered.Flush()
+	}
+
+	contentTypeJSON, err := json.Marshal(contentType)
+	if err != nil {
+		return err
+	}
+
+	context.Header("Content-Type", "application/json; charset=utf-8")
+	context.Status(http.StatusOK)
+
+	buffered.WriteString(`{"contentType":` + string(contentTypeJSON) + `,"data":"`)
+
+	encoder := base64.NewEncoder(base64.StdEncoding, buffered)
+
+	err = write(encoder)
+	if err != nil {
+		return err
+	}
+
+	err = encoder.Close()
+	if err != nil {
+		return err
+	}
+
+	buffered.WriteString(`"}`)
+
+	return buffered.Flush()
+}
+
+func streamAnimation(context *gin.Context, animation *imgconversion.Animation, options imgconversion.EncodeOptions, asBase64 bool, tee io.Writer) error {
+	return sendResult(context, imgconversion.ContentTypeOf(options.Format), asBase64, func(writer io.Writer) error {
+		if tee != nil {
+			writer = io.MultiWriter(writer, tee)
+		}
+
+		return writeAnimation(writer, animation, options)
+	})
+}
+
+//antes do primeiro byte ainda dá para responder com o erro, depois a conexão é fechada para o cliente não receber uma imagem cortada como se estivesse completa
+
+func sendStreamError(context *gin.Context, err error) {
+	if !context.Writer.Written() {
+		header := context.Writer.Header()
+		header.Del("Content-Type")
+		header.Del("ETag")
+		header.Del("X-Cache")
+
+		sendError(context, err)
+		return
+	}
+
+	context.Set(errorCodeKey, string(classifyError(context, err).Code))
+	logWith("error", logFields{"requestId": getRequestID(context), "error": err.Error()}, "response failed after it started")
+
+	conn, _, hijackErr := context.Writer.Hijack()
+	if hijackErr != nil {
+		//HTTP/2 não deixa tomar a conexão, o net/http cancela só esse stream
+		panic(http.ErrAbortHandler)
+	}
+
+	conn.Close()
+	context.Abort()
+}
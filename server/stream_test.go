@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+//ruído não comprime, assim o upload tem o tamanho de uma foto de verdade
+
+func noisePNG(b *testing.B, width int, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	random := rand.New(rand.NewSource(1))
+	random.Read(img.Pix)
+
+	var encoded bytes.Buffer
+
+	err := png.Encode(&encoded, img)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return encoded.Bytes()
+}
+
+func benchmarkUpload(b *testing.B, load func(context *gin.Context) error) {
+	gin.SetMode(gin.TestMode)
+
+	var body bytes.Buffer
+
+	writer := multipart.NewWriter(&body)
+
+	part, err := writer.CreateFormFile("img", "noise.png")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	part.Write(noisePNG(b, 1000, 1000))
+	writer.Close()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(body.Len()))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		context, _ := gin.CreateTestContext(httptest.NewRecorder())
+		context.Request = httptest.NewRequest(http.MethodPost, "/process-img/not", bytes.NewReader(body.Bytes()))
+		context.Request.Header.Set("Content-Type", writer.FormDataContentType())
+
+		err := load(context)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStreamingUpload(b *testing.B) {
+	benchmarkUpload(b, func(context *gin.Context) error {
+		_, err := loadAnimationsFromStream(context, "img")
+
+		return err
+	})
+}
+
+//o caminho antigo, que guardava o arquivo com FormFile e lia tudo antes de decodificar
+
+func BenchmarkBufferedUpload(b *testing.B) {
+	benchmarkUpload(b, func(context *gin.Context) error {
+		file, _, err := context.Request.FormFile("img")
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+
+		options, err := getLoadOptionsFromParams(context)
+		if err != nil {
+			return err
+		}
+
+		_, _, err = decodeAnimation(context, bytes.NewReader(data), options)
+
+		return err
+	})
+}